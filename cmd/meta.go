@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/wubin1989/esdump/v2/core"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+var (
+	metaInput    string
+	metaOutput   string
+	metaKinds    string
+	metaPatterns string
+	metaDryRun   bool
+	metaYes      bool
+)
+
+// metaCmd copies cluster-level objects governing new indices, which are not part of any index
+var metaCmd = &cobra.Command{
+	Use:   "meta",
+	Short: "copy templates, ILM policies, ingest pipelines and stored scripts from one elasticsearch to another",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		copier, err := core.NewMetaCopier(core.MetaConfig{
+			Input:    metaInput,
+			Output:   metaOutput,
+			Kinds:    metaKinds,
+			Patterns: metaPatterns,
+		})
+		if err != nil {
+			exit(err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		changes, err := copier.Plan(ctx)
+		if err != nil {
+			exit(err)
+		}
+		counts := make(map[string]int)
+		for _, change := range changes {
+			counts[change.Action]++
+			if change.Action != core.MetaUnchanged {
+				fmt.Printf("%s %s %s\n%s\n", change.Action, change.Kind, change.Name, change.Diff)
+			}
+		}
+		pending := counts[core.MetaCreate] + counts[core.MetaUpdate]
+		fmt.Printf("%d to create, %d to update, %d unchanged\n", counts[core.MetaCreate], counts[core.MetaUpdate], counts[core.MetaUnchanged])
+		if metaDryRun || pending == 0 {
+			return
+		}
+		if !metaYes && !confirm(fmt.Sprintf("apply %d changes to target elasticsearch?", pending)) {
+			fmt.Fprintln(os.Stderr, "nothing has been written")
+			return
+		}
+		if err = copier.Apply(ctx, changes); err != nil {
+			exit(err)
+		}
+	},
+}
+
+// confirm asks the question on stderr and reads the answer from stdin, only yes is taken as yes
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	metaCmd.Flags().StringVarP(&metaInput, "input", "i", "", "source elasticsearch connection url")
+	metaCmd.Flags().StringVarP(&metaOutput, "output", "o", "", "target elasticsearch connection url")
+	metaCmd.Flags().StringVar(&metaKinds, "kinds", "", `kinds of objects to copy separated by comma, "stored_script", "ingest_pipeline", "ilm_policy", "component_template", "index_template" and "template" for legacy templates, empty means all`)
+	metaCmd.Flags().StringVar(&metaPatterns, "patterns", "", `name patterns of objects to copy separated by comma, such as "logs-*", empty means all`)
+	metaCmd.Flags().BoolVar(&metaDryRun, "dry-run", false, `only print the diff of objects to create or update`)
+	metaCmd.Flags().BoolVarP(&metaYes, "yes", "y", false, `apply changes without asking for confirmation`)
+	metaCmd.MarkFlagRequired("input")
+	metaCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(metaCmd)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/wubin1989/esdump/v2/core"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	replayFile            string
+	replayOutput          string
+	replayDeadLetter      string
+	replayBatchSize       int
+	replayRetries         int
+	replayRetryBackoff    time.Duration
+	replayRetryMaxBackoff time.Duration
+)
+
+// replayDlqCmd re-submits docs of a dead letter file, e.g. after the mapping of target index has been fixed
+var replayDlqCmd = &cobra.Command{
+	Use:   "replay-dlq",
+	Short: "re-submit docs of a dead letter file to target elasticsearch",
+	Long:  ``,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		summary, err := core.ReplayDeadLetters(ctx, replayFile, core.Config{
+			Output:          replayOutput,
+			ScrollSize:      replayBatchSize,
+			Retries:         replayRetries,
+			RetryBackoff:    replayRetryBackoff,
+			RetryMaxBackoff: replayRetryMaxBackoff,
+			DeadLetter:      replayDeadLetter,
+		})
+		fmt.Fprintln(os.Stderr, summary)
+		if ctx.Err() != nil {
+			os.Exit(exitInterrupted)
+		}
+		if err != nil {
+			exit(err)
+		}
+	},
+}
+
+func init() {
+	replayDlqCmd.Flags().StringVarP(&replayFile, "file", "f", "", "dead letter file written by --dlq flag")
+	replayDlqCmd.Flags().StringVarP(&replayOutput, "output", "o", "", `target elasticsearch connection url`)
+	replayDlqCmd.Flags().StringVar(&replayDeadLetter, "dlq", "", `NDJSON file which docs rejected again are appended to, empty means stopping at the first rejected doc`)
+	replayDlqCmd.Flags().IntVarP(&replayBatchSize, "limit", "l", 1000, `number of docs in one bulk request`)
+	replayDlqCmd.Flags().IntVar(&replayRetries, "retries", 3, `how many times a failed bulk request or rejected docs are retried, only docs rejected with retryable status such as 429 are sent again`)
+	replayDlqCmd.Flags().DurationVar(&replayRetryBackoff, "retry-backoff", 500*time.Millisecond, `wait before the first retry, it doubles on each retry with random jitter`)
+	replayDlqCmd.Flags().DurationVar(&replayRetryMaxBackoff, "retry-max-backoff", 30*time.Second, `max wait between retries`)
+	replayDlqCmd.MarkFlagRequired("file")
+	replayDlqCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(replayDlqCmd)
+}
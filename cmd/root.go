@@ -1,26 +1,53 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/wubin1989/esdump/v2/core"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 const version = "v1.0.0"
 
 var (
-	input      string
-	output     string
-	dumpType   string
-	dateField  string
-	startDate  string
-	endDate    string
-	step       time.Duration
-	scrollSize int
-	descending bool
-	zone       string
-	includes   string
-	excludes   string
+	input           string
+	output          string
+	dumpType        string
+	dateField       string
+	startDate       string
+	endDate         string
+	step            time.Duration
+	scrollSize      int
+	descending      bool
+	zone            string
+	includes        string
+	excludes        string
+	checkpoint      string
+	resume          bool
+	workers         int
+	bulkConcurrency int
+	slices          int
+	dateUnit        string
+	retries         int
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	deadLetter      string
+	format          string
+	compress        string
+	csvJoiner       string
+	settings        string
+	aliases         bool
+	aliasRename     string
+	pipelines       bool
+	swapAlias       string
+	verifyCount     bool
+	oldIndex        string
 )
 
 // rootCmd is the base command when called without any subcommands
@@ -30,24 +57,132 @@ var rootCmd = &cobra.Command{
 	Short:   "migrate index from one elasticsearch to another",
 	Long:    ``,
 	Run: func(cmd *cobra.Command, args []string) {
-		dumper := core.NewDumper(core.Config{
-			Input:      input,
-			Output:     output,
-			DumpType:   dumpType,
-			DateField:  dateField,
-			StartDate:  startDate,
-			EndDate:    endDate,
-			Step:       step,
-			ScrollSize: scrollSize,
-			Descending: descending,
-			Zone:       zone,
-			Includes:   includes,
-			Excludes:   excludes,
-		})
-		dumper.Dump()
+		conf := core.Config{
+			Input:           input,
+			Output:          output,
+			DumpType:        dumpType,
+			DateField:       dateField,
+			StartDate:       startDate,
+			EndDate:         endDate,
+			Step:            step,
+			ScrollSize:      scrollSize,
+			Descending:      descending,
+			Zone:            zone,
+			Includes:        includes,
+			Excludes:        excludes,
+			Checkpoint:      checkpoint,
+			Resume:          resume,
+			Workers:         workers,
+			BulkConcurrency: bulkConcurrency,
+			Slices:          slices,
+			DateUnit:        dateUnit,
+			Retries:         retries,
+			RetryBackoff:    retryBackoff,
+			RetryMaxBackoff: retryMaxBackoff,
+			DeadLetter:      deadLetter,
+			Format:          format,
+			Compress:        compress,
+			CSVJoiner:       csvJoiner,
+			Settings:        settings,
+			Aliases:         aliases,
+			AliasRename:     aliasRename,
+			Pipelines:       pipelines,
+			SwapAlias:       swapAlias,
+			VerifyCount:     verifyCount,
+			OldIndex:        oldIndex,
+		}
+		if core.IsIndexPattern(input) || strings.Contains(output, core.IndexPlaceholder) {
+			dumpIndices(conf)
+			return
+		}
+		dumper, err := core.NewDumper(conf)
+		if err != nil {
+			exit(err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = dumper.Dump(ctx)
+		if summary := dumper.Summary(); summary.TotalWindows > 0 {
+			fmt.Fprintln(os.Stderr)
+			fmt.Fprintln(os.Stderr, summary)
+		}
+		if ctx.Err() != nil {
+			if checkpoint != "" {
+				fmt.Fprintln(os.Stderr, "run again with --resume flag to continue from the checkpoint")
+			}
+			os.Exit(exitInterrupted)
+		}
+		if err != nil {
+			exit(err)
+		}
 	},
 }
 
+// dumpIndices dumps each index matched by the input url one by one, and prints a report of all indices at last
+func dumpIndices(conf core.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reports, err := core.DumpIndices(ctx, conf, func(i, total int, report core.IndexReport) {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s -> %s\n", i+1, total, report.Index, report.Output)
+	})
+	if len(reports) > 0 {
+		var docs int64
+		fmt.Fprintln(os.Stderr)
+		for _, report := range reports {
+			fmt.Fprintln(os.Stderr, report)
+			docs += report.Summary.Docs
+		}
+		fmt.Fprintf(os.Stderr, "dumped %d docs of %d indices\n", docs, len(reports))
+	}
+	if ctx.Err() != nil {
+		if conf.Checkpoint != "" {
+			fmt.Fprintln(os.Stderr, "run again with --resume flag to continue from the checkpoint")
+		}
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
+		exit(err)
+	}
+}
+
+// exit codes telling which kind of error esdump fails with
+const (
+	exitError           = 1
+	exitParseError      = 2
+	exitConnectionError = 3
+	exitMappingError    = 4
+	exitBulkError       = 5
+	exitInterrupted     = 130
+)
+
+// exit prints err to stderr and exits with the exit code of its kind
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	var bulkErr *core.BulkError
+	if errors.As(err, &bulkErr) {
+		for _, item := range bulkErr.Failed {
+			fmt.Fprintf(os.Stderr, "  doc %s rejected with status %d: %s\n", item.Id, item.Status, item.Error.Reason)
+		}
+	}
+	var (
+		parseErr      *core.ParseError
+		connectionErr *core.ConnectionError
+		mappingErr    *core.MappingError
+	)
+	switch {
+	case errors.As(err, &parseErr):
+		os.Exit(exitParseError)
+	case errors.As(err, &connectionErr):
+		os.Exit(exitConnectionError)
+	case errors.As(err, &mappingErr):
+		os.Exit(exitMappingError)
+	case errors.As(err, &bulkErr):
+		os.Exit(exitBulkError)
+	default:
+		os.Exit(exitError)
+	}
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -55,10 +190,10 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringVarP(&input, "input", "i", "", "source elasticsearch connection url")
-	rootCmd.Flags().StringVarP(&output, "output", "o", "", `target elasticsearch connection url`)
+	rootCmd.Flags().StringVarP(&input, "input", "i", "", "source elasticsearch connection url, whose index may be a comma list of index patterns such as logs-2023.*, or file:///path/to/dir, file:///path/to/file.ndjson, file:///path/to/file.bulk or s3://bucket/prefix to import dumped files, or - to read docs in _bulk API format from stdin")
+	rootCmd.Flags().StringVarP(&output, "output", "o", "", "target elasticsearch connection url, in which {index} is replaced by name of source index, or file:///path/to/dir or s3://bucket/prefix to export mapping, settings and docs as NDJSON files, compressed as --compress specifies, or - to write docs in _bulk API format to stdout")
 	rootCmd.Flags().StringVarP(&dumpType, "type", "t", "", `migration type, such as "mapping", "data", empty means both`)
-	rootCmd.Flags().StringVarP(&dateField, "date", "d", "", `date field of docs`)
+	rootCmd.Flags().StringVarP(&dateField, "date", "d", "", `date field of docs, empty means dumping all docs of the index without time windows`)
 	rootCmd.Flags().StringVarP(&startDate, "start", "s", "", `start date, use time.Local as time zone, you may need to set TZ environment variable ahead`)
 	rootCmd.Flags().StringVarP(&endDate, "end", "e", "", `end date, use time.Local as time zone, you may need to set TZ environment variable ahead`)
 	rootCmd.Flags().StringVarP(&zone, "zone", "z", "UTC", `time zone of the date type field specified by date flag`)
@@ -67,9 +202,26 @@ func init() {
 	rootCmd.Flags().BoolVar(&descending, "desc", false, `ascending or descending order by the date type field specified by date flag`)
 	rootCmd.Flags().DurationVar(&step, "step", 24*time.Hour, `step duration`)
 	rootCmd.Flags().IntVarP(&scrollSize, "limit", "l", 1000, `limit for one scroll, it takes effect on the dumping speed`)
+	rootCmd.Flags().StringVar(&checkpoint, "checkpoint", "", `checkpoint file recording progress of dumping data, such as esdump.checkpoint.json, empty means no checkpoint`)
+	rootCmd.Flags().BoolVar(&resume, "resume", false, `resume dumping data from the checkpoint saved by last run`)
+	rootCmd.Flags().IntVar(&workers, "workers", 1, `number of time windows dumped concurrently`)
+	rootCmd.Flags().IntVar(&bulkConcurrency, "bulk-concurrency", 0, `max bulk requests in flight across all workers, 0 means same as workers`)
+	rootCmd.Flags().IntVar(&slices, "slices", 1, `number of sliced scrolls reading one time window in parallel`)
+	rootCmd.Flags().StringVar(&dateUnit, "date-unit", "", `unit of epoch values if date field is mapped as numeric type, "s" or "ms", empty means detecting it from values`)
+	rootCmd.Flags().IntVar(&retries, "retries", 3, `how many times a failed bulk request or rejected docs are retried, only docs rejected with retryable status such as 429 are sent again`)
+	rootCmd.Flags().DurationVar(&retryBackoff, "retry-backoff", 500*time.Millisecond, `wait before the first retry, it doubles on each retry with random jitter`)
+	rootCmd.Flags().DurationVar(&retryMaxBackoff, "retry-max-backoff", 30*time.Second, `max wait between retries`)
+	rootCmd.Flags().StringVar(&deadLetter, "dlq", "", `NDJSON file which docs rejected by target index are appended to, empty means stopping at the first rejected doc`)
+	rootCmd.Flags().StringVar(&format, "format", "", `layout of docs written to files or stdout, "ndjson" for a doc per line, "bulk" for _bulk API body, "csv" for a row per doc or "parquet" for Parquet files, empty means ndjson for files and bulk for stdout`)
+	rootCmd.Flags().StringVar(&compress, "compress", "", `codec compressing data files of file dumps, "gzip", "zstd" or "none", empty means gzip if the output url ends with .gz, zstd if it ends with .zst, otherwise none`)
+	rootCmd.Flags().StringVar(&csvJoiner, "csv-joiner", ",", `separator joining values of arrays in a CSV cell or a string column of Parquet`)
+	rootCmd.Flags().StringVar(&settings, "settings", "", `index settings overriding those copied from source index, such as "number_of_replicas=0,refresh_interval=-1", a setting with empty value is removed`)
+	rootCmd.Flags().BoolVar(&aliases, "aliases", false, `copy aliases of source index onto target index, along with their filters, routings and write index flags`)
+	rootCmd.Flags().StringVar(&aliasRename, "alias-rename", "", `renames of aliases copied by aliases flag, such as "logs=logs_v2,logs_write=logs_v2_write"`)
+	rootCmd.Flags().BoolVar(&pipelines, "pipelines", false, `copy ingest pipelines named by default_pipeline and final_pipeline settings, along with pipelines and stored scripts they refer to, before creating target index`)
+	rootCmd.Flags().StringVar(&swapAlias, "swap-alias", "", `alias moved from the indices it points to onto target index by one atomic request after dumping succeeds`)
+	rootCmd.Flags().BoolVar(&verifyCount, "verify-count", false, `swap the alias only if target index holds as many docs as source`)
+	rootCmd.Flags().StringVar(&oldIndex, "old-index", "keep", `what to do with indices the swapped alias pointed to, "keep", "close" or "delete"`)
 	rootCmd.MarkFlagRequired("input")
 	rootCmd.MarkFlagRequired("output")
-	if dumpType != "mapping" {
-		rootCmd.MarkFlagRequired("date")
-	}
 }
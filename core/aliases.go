@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// targetAliases returns aliases of source index renamed by Conf.AliasRename, nil unless Conf.Aliases is set
+func (d *Dumper) targetAliases(aliases map[string]interface{}) map[string]interface{} {
+	if !d.Conf.Aliases || len(aliases) == 0 {
+		return nil
+	}
+	renamed := make(map[string]interface{}, len(aliases))
+	for name, alias := range aliases {
+		if to, ok := d.aliasRenames[name]; ok {
+			name = to
+		}
+		renamed[name] = alias
+	}
+	return renamed
+}
+
+// putAliases points aliases at target index, each alias keeps its filter, routing and write index flag.
+// All aliases are added in one request, so either all or none of them are added.
+func (s *esSink) putAliases(ctx context.Context, aliases map[string]interface{}) error {
+	d := s.d
+	service := d.TargetClient.Alias()
+	for name, alias := range aliases {
+		action, err := aliasAddAction(name, alias)
+		if err != nil {
+			return &MappingError{Index: d.TargetIndex, Err: err}
+		}
+		service = service.Action(action.Index(d.TargetIndex))
+	}
+	aliasCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := service.Do(aliasCtx); err != nil {
+		if err = requestError(err, d.Conf.Output, "put aliases error"); isConnectionError(err) {
+			return err
+		}
+		return &MappingError{Index: d.TargetIndex, Err: err}
+	}
+	return nil
+}
+
+// aliasAddAction converts an alias definition as returned by get index API into an add action
+func aliasAddAction(name string, alias interface{}) (*elastic.AliasAddAction, error) {
+	action := elastic.NewAliasAddAction(name)
+	definition, _ := alias.(map[string]interface{})
+	if filter, ok := definition["filter"]; ok {
+		data, err := json.Marshal(filter)
+		if err != nil {
+			return nil, errors.Wrapf(err, "filter of alias %s", name)
+		}
+		action.Filter(elastic.NewRawStringQuery(string(data)))
+	}
+	if routing, ok := definition["index_routing"].(string); ok {
+		action.IndexRouting(routing)
+	}
+	if routing, ok := definition["search_routing"].(string); ok {
+		action.SearchRouting(strings.Split(routing, ",")...)
+	}
+	if isWriteIndex, ok := definition["is_write_index"].(bool); ok {
+		action.IsWriteIndex(isWriteIndex)
+	}
+	return action, nil
+}
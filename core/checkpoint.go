@@ -0,0 +1,144 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint records how far dumping data from one source index to one target index has gone
+type Checkpoint struct {
+	Source     string `json:"source"`
+	Target     string `json:"target"`
+	Descending bool   `json:"descending"`
+	// DateField, StartDate, EndDate and Step are those of the run, windows and boundaries only make sense with them
+	DateField string `json:"dateField"`
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	Step      string `json:"step"`
+	// WindowStart and WindowEnd are bounds of the last fully committed time window
+	WindowStart *time.Time `json:"windowStart,omitempty"`
+	WindowEnd   *time.Time `json:"windowEnd,omitempty"`
+	// Cursor is date of the last committed doc inside the window being dumped,
+	// it is nil if no page of that window has been committed yet
+	Cursor *time.Time `json:"cursor,omitempty"`
+	// Completed is true if all windows of the run have been committed
+	Completed bool      `json:"completed"`
+	Docs      int64     `json:"docs"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Boundary returns the time before which (after which in descending order) all docs have been committed
+func (c Checkpoint) Boundary() *time.Time {
+	if c.Cursor != nil {
+		return c.Cursor
+	}
+	if c.Descending {
+		return c.WindowStart
+	}
+	return c.WindowEnd
+}
+
+// CheckpointStore persists checkpoints of source/target pairs into a json file
+type CheckpointStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewCheckpointStore creates a CheckpointStore backed by the file at path
+func NewCheckpointStore(path string) *CheckpointStore {
+	return &CheckpointStore{
+		path: path,
+	}
+}
+
+func checkpointKey(source, target string) string {
+	return fmt.Sprintf("%s -> %s", source, target)
+}
+
+func (s *CheckpointStore) load() (map[string]Checkpoint, error) {
+	checkpoints := make(map[string]Checkpoint)
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return checkpoints, nil
+		}
+		return nil, errors.Wrap(err, "call ReadFile() error")
+	}
+	if err = json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, errors.Wrapf(err, "checkpoint file %s is broken", s.path)
+	}
+	return checkpoints, nil
+}
+
+// Load returns checkpoint of the source/target pair, nil means no checkpoint has been saved
+func (s *CheckpointStore) Load(source, target string) (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoints, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, ok := checkpoints[checkpointKey(source, target)]
+	if !ok {
+		return nil, nil
+	}
+	return &checkpoint, nil
+}
+
+// Save saves checkpoint of the source/target pair. Checkpoints of other pairs in the same file are kept.
+// The file is replaced by renaming a temporary file, so it won't be left half written if the process dies.
+func (s *CheckpointStore) Save(checkpoint Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	checkpoints, err := s.load()
+	if err != nil {
+		return err
+	}
+	checkpoint.UpdatedAt = time.Now()
+	checkpoints[checkpointKey(checkpoint.Source, checkpoint.Target)] = checkpoint
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "call MarshalIndent() error")
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic replaces the file at path with data by renaming a temporary file,
+// so it won't be left half written if the process dies
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "call TempFile() error")
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "call Write() error")
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "call Close() error")
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "call Rename() error")
+	}
+	return nil
+}
+
+// redactURL strips user info from connection url, so that credentials won't be written into checkpoint file
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
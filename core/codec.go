@@ -0,0 +1,100 @@
+package core
+
+import (
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// codec compresses data files of file dumps on the fly
+type codec string
+
+const (
+	codecNone codec = ""
+	codecGzip codec = "gzip"
+	codecZstd codec = "zstd"
+)
+
+// parseCodec parses codec named by Config.Compress, "none" turns compression off. Empty means def.
+func parseCodec(s string, def codec) (codec, error) {
+	switch c := codec(s); c {
+	case "":
+		return def, nil
+	case "none":
+		return codecNone, nil
+	case codecGzip, codecZstd:
+		return c, nil
+	}
+	return codecNone, &ParseError{Field: "compress", Value: s, Err: errors.New("compress should be gzip, zstd or none")}
+}
+
+// codecOf chooses codec by suffix of path, .gz for gzip and .zst for zstd
+func codecOf(path string) codec {
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return codecGzip
+	case strings.HasSuffix(path, ".zst"):
+		return codecZstd
+	}
+	return codecNone
+}
+
+// ext returns suffix appended to names of compressed files
+func (c codec) ext() string {
+	switch c {
+	case codecGzip:
+		return ".gz"
+	case codecZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// writer wraps w to compress data written to it, the returned writer should be closed to flush all data to w
+func (c codec) writer(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case codecNone:
+		return nopWriteCloser{w}, nil
+	case codecGzip:
+		return gzip.NewWriter(w), nil
+	case codecZstd:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, errors.Wrap(err, "create zstd writer error")
+		}
+		return enc, nil
+	}
+	return nil, errors.Errorf("unknown codec %s", c)
+}
+
+// reader wraps r to decompress data read from it
+func (c codec) reader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case codecNone:
+		return ioutil.NopCloser(r), nil
+	case codecGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "create gzip reader error")
+		}
+		return gr, nil
+	case codecZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "create zstd reader error")
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, errors.Errorf("unknown codec %s", c)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
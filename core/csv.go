@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metaColumns are CSV columns taken from metadata of docs rather than their sources
+var metaColumns = map[string]func(hit *elastic.SearchHit) string{
+	"_id":      func(hit *elastic.SearchHit) string { return hit.Id },
+	"_index":   func(hit *elastic.SearchHit) string { return hit.Index },
+	"_routing": func(hit *elastic.SearchHit) string { return hit.Routing },
+}
+
+// csvColumns resolves CSV columns. Includes are taken as columns in their order, a pattern with wildcards
+// or naming an object is expanded to the leaf fields of the mapping it matches. Without includes, columns are
+// _id followed by all leaf fields of the mapping in alphabetical order. Fields matched by excludes are left out.
+func (d *Dumper) csvColumns(ctx context.Context) ([]string, error) {
+	meta, err := d.sourceMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fields := mappingFields("", meta.Mapping)
+	if meta.Mapping == nil {
+		literal := len(d.Includes) > 0
+		for _, include := range d.Includes {
+			literal = literal && !strings.Contains(include, "*")
+		}
+		if !literal {
+			return nil, &ParseError{Field: "includes", Value: d.Conf.Includes, Err: errors.New("CSV columns should be listed by includes without wildcards as source has no mapping")}
+		}
+	}
+	patterns := d.Includes
+	if len(patterns) == 0 {
+		patterns = append([]string{"_id"}, fields...)
+	}
+	return expandColumns(patterns, fields, compilePatterns(d.Excludes)), nil
+}
+
+// mappingFields returns dotted paths of leaf fields under properties of mapping, sorted by name at each level
+func mappingFields(prefix string, mapping map[string]interface{}) []string {
+	properties, _ := mapping["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []string
+	for _, name := range names {
+		path := prefix + name
+		property, _ := properties[name].(map[string]interface{})
+		if _, ok := property["properties"]; ok {
+			fields = append(fields, mappingFields(path+".", property)...)
+			continue
+		}
+		fields = append(fields, path)
+	}
+	return fields
+}
+
+// expandColumns replaces each pattern by fields it matches, including fields of objects it matches.
+// A pattern matching no field is kept as is, e.g. _id or a field missing from the mapping.
+func expandColumns(patterns []string, fields []string, excludes []*regexp.Regexp) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	add := func(column string) {
+		if !seen[column] && !matchField(excludes, column) {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	for _, pattern := range patterns {
+		compiled := compilePatterns([]string{pattern})
+		if len(compiled) == 0 {
+			continue
+		}
+		matched := false
+		for _, field := range fields {
+			if matchField(compiled, field) {
+				matched = true
+				add(field)
+			}
+		}
+		if !matched && !strings.Contains(pattern, "*") {
+			add(strings.TrimSpace(pattern))
+		}
+	}
+	return columns
+}
+
+// matchField reports whether patterns match path or an object containing it
+func matchField(patterns []*regexp.Regexp, path string) bool {
+	for {
+		if matchAny(patterns, path) {
+			return true
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
+
+// csvEncoder writes a header row of columns followed by a row per doc. Values are looked up by dotted paths
+// through nested objects and arrays of objects, values of arrays are joined by joiner and objects are written as JSON.
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []string
+	joiner  string
+	header  bool
+}
+
+func newCSVEncoder(w io.Writer, columns []string, joiner string) *csvEncoder {
+	return &csvEncoder{
+		w:       csv.NewWriter(w),
+		columns: columns,
+		joiner:  joiner,
+	}
+}
+
+func (e *csvEncoder) encode(hits []*elastic.SearchHit) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	row := make([]string, len(e.columns))
+	for _, hit := range hits {
+		var source map[string]interface{}
+		if len(hit.Source) > 0 {
+			decoder := json.NewDecoder(bytes.NewReader(hit.Source))
+			decoder.UseNumber()
+			if err := decoder.Decode(&source); err != nil {
+				return errors.Wrap(err, "decode source error")
+			}
+		}
+		for i, column := range e.columns {
+			if value, ok := metaColumns[column]; ok {
+				row[i] = value(hit)
+				continue
+			}
+			row[i] = e.cell(lookup(source, column))
+		}
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	// rows are passed down on every page, so that nothing but the current page is buffered
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// flush writes the header even if there is no doc, so that an empty window file still has columns
+func (e *csvEncoder) flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write(e.columns)
+}
+
+// cell formats values found at a column, values of arrays are joined
+func (e *csvEncoder) cell(values []interface{}) string {
+	var parts []string
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case nil:
+		case string:
+			parts = append(parts, v)
+		case json.Number:
+			parts = append(parts, v.String())
+		case bool:
+			parts = append(parts, strconv.FormatBool(v))
+		default:
+			data, _ := json.Marshal(v)
+			parts = append(parts, string(data))
+		}
+	}
+	for _, value := range values {
+		collect(value)
+	}
+	return strings.Join(parts, e.joiner)
+}
+
+// lookup returns values at the dotted path of value, arrays of objects are walked element by element.
+// Keys containing dots are matched as well, e.g. a.b.c is found in {"a.b": {"c": 1}}.
+func lookup(value interface{}, path string) []interface{} {
+	var values []interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if field, ok := v[path]; ok {
+			return []interface{}{field}
+		}
+		for i := 0; i < len(path); i++ {
+			if path[i] != '.' {
+				continue
+			}
+			if field, ok := v[path[:i]]; ok {
+				values = append(values, lookup(field, path[i+1:])...)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, lookup(item, path)...)
+		}
+	}
+	return values
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Jeffail/gabs/v2"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// dateField describes how the date field of source docs is mapped
+type dateField struct {
+	Name string
+	// Type is mapping type of the field, such as date, date_nanos, long
+	Type string
+	// Unit is unit of epoch values stored in numeric fields
+	Unit time.Duration
+	// Format is the mapped format of date fields, empty means the default one
+	Format string
+	// Zone is where bounds of range queries are formatted in
+	Zone *time.Location
+}
+
+// defaultDateFormat is the format of date fields mapped without one
+const defaultDateFormat = "strict_date_optional_time||epoch_millis"
+
+// rangeFormats are date formats which keep millisecond precision of checkpoint cursors, by the layout of Go formatting
+// bounds of range queries in them. Empty layout means epoch milliseconds.
+var rangeFormats = map[string]string{
+	"strict_date_optional_time":       "2006-01-02T15:04:05.000Z07:00",
+	"date_optional_time":              "2006-01-02T15:04:05.000Z07:00",
+	"strict_date_optional_time_nanos": "2006-01-02T15:04:05.000Z07:00",
+	"strict_date_time":                "2006-01-02T15:04:05.000Z07:00",
+	"date_time":                       "2006-01-02T15:04:05.000Z07:00",
+	"epoch_millis":                    "",
+}
+
+// rangeFormat returns the first of mapped formats which range queries can use, epoch_millis if there is none,
+// e.g. for custom patterns such as yyyy-MM-dd HH:mm:ss. epoch_millis works whatever format is mapped.
+func rangeFormat(format string) string {
+	if format == "" {
+		format = defaultDateFormat
+	}
+	for _, f := range strings.Split(format, "||") {
+		if _, ok := rangeFormats[strings.TrimSpace(f)]; ok {
+			return strings.TrimSpace(f)
+		}
+	}
+	return "epoch_millis"
+}
+
+// numeric reports whether dates are stored as epoch numbers rather than mapped as date type
+func (f dateField) numeric() bool {
+	switch f.Type {
+	case "long", "integer", "unsigned_long", "double", "float", "scaled_float":
+		return true
+	}
+	return false
+}
+
+// precision is the smallest step between values of the field in range queries
+func (f dateField) precision() time.Duration {
+	if f.numeric() && f.Unit > time.Millisecond {
+		return f.Unit
+	}
+	return time.Millisecond
+}
+
+// value converts t into the value used by range queries on the field
+func (f dateField) value(t time.Time) interface{} {
+	if f.numeric() {
+		if f.Unit == time.Second {
+			return t.Unix()
+		}
+		return toMillis(t)
+	}
+	layout := rangeFormats[rangeFormat(f.Format)]
+	if layout == "" {
+		return toMillis(t)
+	}
+	zone := f.Zone
+	if zone == nil {
+		zone = time.UTC
+	}
+	return t.In(zone).Format(layout)
+}
+
+// time converts value of aggregations or sort values on the field into time
+func (f dateField) time(value float64) time.Time {
+	switch {
+	case f.numeric():
+		return time.Unix(0, int64(math.Round(value*float64(f.Unit)))).In(time.Local)
+	case f.Type == "date_nanos":
+		// sort values on date_nanos fields are nanoseconds, aggregations convert them into milliseconds,
+		// a nanosecond value is always bigger than any millisecond value we could meet
+		if value > 1e15 {
+			return time.Unix(0, int64(value)).In(time.Local)
+		}
+	}
+	return fromMillis(int64(value))
+}
+
+// rangeQuery matches docs whose field value falls into [start, end)
+func (f dateField) rangeQuery(start, end time.Time) elastic.Query {
+	q := elastic.NewRangeQuery(f.Name).Gte(f.value(start)).Lt(f.value(end))
+	if !f.numeric() {
+		q = q.Format(rangeFormat(f.Format))
+	}
+	return q
+}
+
+// resolveDateField looks up mapping of Conf.DateField in source index
+func (d *Dumper) resolveDateField(ctx context.Context) (*dateField, error) {
+	mapping, err := d.SourceClient.GetMapping().Index(d.SourceIndex).Type(d.SourceType).IncludeTypeName(true).Do(ctx)
+	if err != nil {
+		return nil, requestError(err, d.Conf.Input, "get mapping of source index error")
+	}
+	path := []string{d.SourceIndex, "mappings", d.SourceType}
+	for _, name := range strings.Split(d.Conf.DateField, ".") {
+		path = append(path, "properties", name)
+	}
+	container := gabs.Wrap(mapping).Search(path...)
+	if container == nil {
+		return nil, &MappingError{Index: d.SourceIndex, Err: errors.Errorf("date field %s not found", d.Conf.DateField)}
+	}
+	field := &dateField{
+		Name: d.Conf.DateField,
+		Zone: d.Zone,
+	}
+	field.Type, _ = container.Path("type").Data().(string)
+	field.Format, _ = container.Path("format").Data().(string)
+	switch field.Type {
+	case "date", "date_nanos":
+	default:
+		if !field.numeric() {
+			return nil, &MappingError{Index: d.SourceIndex, Err: errors.Errorf("date field %s is mapped as %s, it should be date, date_nanos or numeric type", field.Name, field.Type)}
+		}
+		switch d.Conf.DateUnit {
+		case "s":
+			field.Unit = time.Second
+		case "ms":
+			field.Unit = time.Millisecond
+		case "":
+			if field.Unit, err = d.detectDateUnit(ctx, field.Name); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, &ParseError{Field: "date unit", Value: d.Conf.DateUnit, Err: errors.New(`it should be "s" or "ms"`)}
+		}
+	}
+	return field, nil
+}
+
+// detectDateUnit guesses whether epoch values in a numeric field are seconds or milliseconds by the max value.
+// 1e11 seconds is far beyond year 5000, while 1e11 milliseconds is in 1973.
+func (d *Dumper) detectDateUnit(ctx context.Context, name string) (time.Duration, error) {
+	result, err := d.SourceClient.Search(d.SourceIndex).Type(d.SourceType).Size(0).
+		Aggregation("max", elastic.NewMaxAggregation().Field(name)).Do(ctx)
+	if err != nil {
+		return 0, requestError(err, d.Conf.Input, "detect date unit error")
+	}
+	max, _ := result.Aggregations.Max("max")
+	if max == nil {
+		return dateUnitOf(nil), nil
+	}
+	return dateUnitOf(max.Value), nil
+}
+
+// dateUnitOf returns the unit of epoch values whose max is max, milliseconds if there is no value
+func dateUnitOf(max *float64) time.Duration {
+	if max != nil && math.Abs(*max) < 1e11 {
+		return time.Second
+	}
+	return time.Millisecond
+}
+
+// cursor returns date of the hit from its sort values, nil if the hit wasn't sorted by date field
+func (f dateField) cursor(hit *elastic.SearchHit) *time.Time {
+	if len(hit.Sort) == 0 {
+		return nil
+	}
+	value, ok := sortValue(hit.Sort[0])
+	if !ok {
+		return nil
+	}
+	t := f.time(value)
+	return &t
+}
+
+// sortValue converts sort value of a hit into float64
+func sortValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRangeFormat(t *testing.T) {
+	assert.Equal(t, "strict_date_optional_time", rangeFormat(""))
+	assert.Equal(t, "epoch_millis", rangeFormat("epoch_millis||yyyy-MM-dd"))
+	assert.Equal(t, "date_time", rangeFormat("yyyy-MM-dd HH:mm:ss || date_time"))
+	assert.Equal(t, "epoch_millis", rangeFormat("yyyy-MM-dd HH:mm:ss"))
+	// seconds would lose millisecond precision of cursors
+	assert.Equal(t, "epoch_millis", rangeFormat("epoch_second"))
+}
+
+func TestDateField_Value(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	at := time.Date(2020, 6, 1, 0, 0, 0, 5e6, time.UTC)
+	assert.Equal(t, "2020-06-01T00:00:00.005Z", dateField{Type: "date"}.value(at))
+	assert.Equal(t, "2020-06-01T08:00:00.005+08:00", dateField{Type: "date", Zone: shanghai}.value(at))
+	assert.Equal(t, at.UnixNano()/1e6, dateField{Type: "date", Format: "yyyy-MM-dd HH:mm:ss"}.value(at))
+	assert.Equal(t, at.Unix(), dateField{Type: "long", Unit: time.Second}.value(at))
+	assert.Equal(t, at.UnixNano()/1e6, dateField{Type: "long", Unit: time.Millisecond}.value(at))
+}
+
+func TestDateField_Precision(t *testing.T) {
+	assert.Equal(t, time.Millisecond, dateField{Type: "date"}.precision())
+	assert.Equal(t, time.Millisecond, dateField{Type: "date_nanos"}.precision())
+	assert.Equal(t, time.Second, dateField{Type: "long", Unit: time.Second}.precision())
+	assert.Equal(t, time.Millisecond, dateField{Type: "long", Unit: time.Millisecond}.precision())
+}
+
+func TestDateField_RangeQuery(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	src, err := dateField{Name: "createAt", Type: "date", Format: "yyyy/MM/dd||epoch_millis"}.rangeQuery(start, end).Source()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"range": map[string]interface{}{"createAt": map[string]interface{}{
+		"format":        "epoch_millis",
+		"from":          start.UnixNano() / 1e6,
+		"include_lower": true,
+		"include_upper": false,
+		"to":            end.UnixNano() / 1e6,
+	}}}, src)
+	src, err = dateField{Name: "createAt", Type: "long", Unit: time.Second}.rangeQuery(start, end).Source()
+	assert.NoError(t, err)
+	assert.NotContains(t, src.(map[string]interface{})["range"].(map[string]interface{})["createAt"], "format")
+}
+
+func TestDateField_Time(t *testing.T) {
+	at := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.True(t, at.Equal(dateField{Type: "date"}.time(float64(at.UnixNano()/1e6))))
+	assert.True(t, at.Equal(dateField{Type: "long", Unit: time.Second}.time(float64(at.Unix()))))
+	assert.True(t, at.Equal(dateField{Type: "date_nanos"}.time(float64(at.UnixNano()))))
+}
+
+func TestDateUnitOf(t *testing.T) {
+	seconds, millis := 1.5e9, 1.5e12
+	assert.Equal(t, time.Second, dateUnitOf(&seconds))
+	assert.Equal(t, time.Millisecond, dateUnitOf(&millis))
+	assert.Equal(t, time.Millisecond, dateUnitOf(nil))
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DeadLetter is a doc rejected by target index, it is written as one line of the dead letter file
+type DeadLetter struct {
+	Id string `json:"_id"`
+	// Index is the source index of the doc
+	Index     string          `json:"_index"`
+	Routing   string          `json:"_routing,omitempty"`
+	Status    int             `json:"status"`
+	ErrorType string          `json:"error_type"`
+	Reason    string          `json:"reason"`
+	Source    json.RawMessage `json:"_source"`
+}
+
+// hit converts the dead letter back to a search hit, so that it can be bulk saved again
+func (l DeadLetter) hit() *elastic.SearchHit {
+	return &elastic.SearchHit{
+		Index:   l.Index,
+		Id:      l.Id,
+		Routing: l.Routing,
+		Source:  l.Source,
+	}
+}
+
+// deadLetterWriter appends dead letters to a NDJSON file
+type deadLetterWriter struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	count int64
+}
+
+// newDeadLetterWriter opens the dead letter file at path for appending, so that dead letters of resumed runs are kept
+func newDeadLetterWriter(path string) (*deadLetterWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open dead letter file error")
+	}
+	return &deadLetterWriter{
+		file: file,
+		w:    bufio.NewWriter(file),
+	}, nil
+}
+
+// write writes rejected hits along with their failed bulk response items, and flushes them to the file
+func (w *deadLetterWriter) write(hits []*elastic.SearchHit, items []*elastic.BulkResponseItem) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	encoder := json.NewEncoder(w.w)
+	for i, hit := range hits {
+		letter := DeadLetter{
+			Id:      hit.Id,
+			Index:   hit.Index,
+			Routing: hit.Routing,
+			Status:  items[i].Status,
+			Source:  hit.Source,
+		}
+		if items[i].Error != nil {
+			letter.ErrorType = items[i].Error.Type
+			letter.Reason = items[i].Error.Reason
+		}
+		if err := encoder.Encode(letter); err != nil {
+			return errors.Wrap(err, "write dead letter error")
+		}
+		w.count++
+	}
+	if err := w.w.Flush(); err != nil {
+		return errors.Wrap(err, "write dead letter error")
+	}
+	return nil
+}
+
+func (w *deadLetterWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.w.Flush(); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "write dead letter error")
+	}
+	return w.file.Close()
+}
+
+// ReadDeadLetters reads the dead letter file at path and passes dead letters to fn one by one
+func ReadDeadLetters(path string, fn func(letter DeadLetter) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open dead letter file error")
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var letter DeadLetter
+		if err = decoder.Decode(&letter); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return &ParseError{Field: "dead letter file", Value: path, Err: err}
+		}
+		if err = fn(letter); err != nil {
+			return err
+		}
+	}
+}
+
+// ReplayDeadLetters submits docs of the dead letter file at path to the target index given by conf.Output,
+// retrying as configured by conf. Docs rejected again are written to conf.DeadLetter if it is set,
+// otherwise BulkError is returned.
+func ReplayDeadLetters(ctx context.Context, path string, conf Config) (Summary, error) {
+	var summary Summary
+	outputUrl, err := url.Parse(conf.Output)
+	if err != nil {
+		return summary, &ParseError{Field: "output", Value: redactURL(conf.Output), Err: err}
+	}
+	index, esType := indexAndType(outputUrl)
+	if index == "" {
+		return summary, &ParseError{Field: "output", Value: redactURL(conf.Output), Err: errors.New("index name should not be empty")}
+	}
+	if conf.DeadLetter != "" && sameFile(conf.DeadLetter, path) {
+		return summary, errors.New("docs rejected again cannot be written to the dead letter file being replayed")
+	}
+	target, err := newClient(outputUrl)
+	if err != nil {
+		return summary, err
+	}
+	d := &Dumper{
+		Conf:         conf,
+		TargetClient: target,
+		TargetIndex:  index,
+		TargetType:   esType,
+		bulkSlots:    make(chan struct{}, 1),
+	}
+	if conf.DeadLetter != "" {
+		if d.deadLetters, err = newDeadLetterWriter(conf.DeadLetter); err != nil {
+			return summary, err
+		}
+		defer d.deadLetters.Close()
+	}
+	batchSize := conf.ScrollSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	begin := time.Now()
+	var batch []*elastic.SearchHit
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := d.bulkSave(ctx, batch); err != nil {
+			return err
+		}
+		summary.Docs += int64(len(batch))
+		batch = nil
+		return nil
+	}
+	err = ReadDeadLetters(path, func(letter DeadLetter) error {
+		batch = append(batch, letter.hit())
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	summary.Elapsed = time.Since(begin)
+	summary.Interrupted = ctx.Err() != nil
+	if d.deadLetters != nil {
+		summary.Docs -= d.deadLetters.count
+		summary.DeadLetters = d.deadLetters.count
+	}
+	if err != nil {
+		return summary, err
+	}
+	if _, err = d.TargetClient.Refresh(d.TargetIndex).Do(ctx); err != nil {
+		return summary, requestError(err, d.Conf.Output, "refresh target index error")
+	}
+	return summary, nil
+}
+
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -2,15 +2,12 @@ package core
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"github.com/Jeffail/gabs/v2"
-	"github.com/araddon/dateparse"
 	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
 	"github.com/schollz/progressbar/v3"
 	"github.com/unionj-cloud/go-doudou/toolkit/constants"
 	"github.com/unionj-cloud/go-doudou/toolkit/stringutils"
-	"github.com/wubin1989/go-esutils/v2"
 	"net/url"
 	"os"
 	"strings"
@@ -27,9 +24,58 @@ type Config struct {
 	Step       time.Duration
 	ScrollSize int
 	Descending bool
-	Zone       string
-	Includes   string
-	Excludes   string
+	// Zone is time zone of dates without offset in docs, defaults to UTC as elasticsearch takes them
+	Zone     string
+	Includes string
+	Excludes string
+	// DateUnit is unit of epoch values if DateField is mapped as numeric type, "s" or "ms",
+	// empty means detecting it from values
+	DateUnit string
+	// Checkpoint is path of the checkpoint file, empty means no checkpoint will be saved
+	Checkpoint string
+	// Resume continues dumping data from the checkpoint saved by last run
+	Resume bool
+	// Workers is number of time windows dumped concurrently, defaults to 1
+	Workers int
+	// Slices is number of sliced scrolls reading one window in parallel, defaults to 1.
+	// Checkpoints of sliced windows have no cursor, so an interrupted window is dumped again from its start.
+	Slices int
+	// BulkConcurrency caps bulk requests in flight across all workers, defaults to Workers
+	BulkConcurrency int
+	// Retries is how many times a failed bulk request or docs rejected with retryable status are retried
+	Retries int
+	// RetryBackoff is the wait before the first retry, it doubles on each retry. Defaults to 500ms.
+	RetryBackoff time.Duration
+	// RetryMaxBackoff caps the wait between retries, zero means no cap
+	RetryMaxBackoff time.Duration
+	// DeadLetter is path of the NDJSON file which docs rejected by target index are appended to,
+	// empty means dumping stops at the first rejected doc
+	DeadLetter string
+	// Format is how docs are laid out in data files or stdout, "ndjson", "bulk", "csv" or "parquet". Empty means ndjson
+	// for data files and bulk for stdout.
+	Format string
+	// Compress is codec compressing data files of file dumps, "gzip", "zstd" or "none". Empty means choosing it by
+	// suffix of the output directory, .gz for gzip and .zst for zstd, as dumps written before Compress was added do.
+	Compress string
+	// CSVJoiner joins values of arrays in a CSV cell or a string column of Parquet, defaults to comma
+	CSVJoiner string
+	// Settings are comma separated index settings overriding those of source index when target index is created,
+	// e.g. "number_of_replicas=0,refresh_interval=-1". A setting with empty value is removed.
+	Settings string
+	// Aliases copies aliases of source index onto target index, along with their filters and routings
+	Aliases bool
+	// AliasRename are comma separated old=new pairs renaming aliases copied onto target index
+	AliasRename string
+	// Pipelines copies ingest pipelines named by default_pipeline and final_pipeline settings of target index,
+	// along with pipelines and stored scripts they refer to, to the cluster of target index before creating it
+	Pipelines bool
+	// SwapAlias is the alias moved from the indices it points to onto target index by one atomic request
+	// after dumping succeeds, empty means no alias is swapped
+	SwapAlias string
+	// VerifyCount swaps the alias only if target index holds as many docs as source index or dump directory
+	VerifyCount bool
+	// OldIndex is what is done to indices the swapped alias pointed to, "keep", "close" or "delete". Empty means keep.
+	OldIndex string
 }
 
 type Dumper struct {
@@ -45,74 +91,113 @@ type Dumper struct {
 	Zone         *time.Location
 	Includes     []string `json:"includes"`
 	Excludes     []string `json:"excludes"`
+	Checkpoints  *CheckpointStore
+	source       source
+	sink         sink
+	bulkSlots    chan struct{}
+	dateField    *dateField
+	deadLetters  *deadLetterWriter
+	// layout is how docs are laid out by file and stream sinks, nil if target is an index
+	layout *layout
+	meta   *indexMeta
+	// settings are overrides of index settings parsed from Conf.Settings
+	settings map[string]string
+	// pipelineSettings are pipeline settings of target index held back until data has been copied
+	pipelineSettings map[string]interface{}
+	// aliasRenames maps names of source aliases to names of target aliases, parsed from Conf.AliasRename
+	aliasRenames map[string]string
+	summary      Summary
 }
 
-func NewDumper(conf Config) *Dumper {
-	inputUrl, err := url.Parse(conf.Input)
-	if err != nil {
-		panic(err)
-	}
+// newClient creates a client connecting to the cluster of rawURL, basic auth credentials are taken from user info of rawURL
+func newClient(u *url.URL) (*elastic.Client, error) {
 	options := []elastic.ClientOptionFunc{
-		elastic.SetURL([]string{fmt.Sprintf("%s://%s", inputUrl.Scheme, inputUrl.Host)}...),
+		elastic.SetURL([]string{fmt.Sprintf("%s://%s", u.Scheme, u.Host)}...),
 		elastic.SetGzip(true),
 	}
-	username := inputUrl.User.Username()
-	password, _ := inputUrl.User.Password()
+	username := u.User.Username()
+	password, _ := u.User.Password()
 	if stringutils.IsNotEmpty(username) {
 		options = append(options, elastic.SetBasicAuth(username, password))
 	}
-	source, err := elastic.NewSimpleClient(options...)
+	client, err := elastic.NewSimpleClient(options...)
 	if err != nil {
-		panic(err)
+		return nil, &ConnectionError{URL: redactURL(u.String()), Err: err}
 	}
-	outputUrl, err := url.Parse(conf.Output)
-	if err != nil {
-		panic(err)
-	}
-	options = []elastic.ClientOptionFunc{
-		elastic.SetURL([]string{fmt.Sprintf("%s://%s", outputUrl.Scheme, outputUrl.Host)}...),
-		elastic.SetGzip(true),
+	return client, nil
+}
+
+// indexAndType parses index name and type from path of connection url, type defaults to _doc
+func indexAndType(u *url.URL) (index, esType string) {
+	path := strings.Split(strings.TrimSpace(strings.ReplaceAll(u.Path, "/", " ")), " ")
+	index = path[0]
+	if len(path) > 1 {
+		esType = path[1]
+	} else {
+		esType = "_doc"
 	}
-	username = outputUrl.User.Username()
-	password, _ = outputUrl.User.Password()
-	if stringutils.IsNotEmpty(username) {
-		options = append(options, elastic.SetBasicAuth(username, password))
+	return
+}
+
+func NewDumper(conf Config) (*Dumper, error) {
+	inputUrl, err := url.Parse(conf.Input)
+	if err != nil {
+		return nil, &ParseError{Field: "input", Value: redactURL(conf.Input), Err: err}
 	}
-	target, err := elastic.NewSimpleClient(options...)
+	outputUrl, err := url.Parse(conf.Output)
 	if err != nil {
-		panic(err)
+		return nil, &ParseError{Field: "output", Value: redactURL(conf.Output), Err: err}
 	}
 	var (
-		sourceIndex, sourceType, targetIndex, targetType string
+		source                  *elastic.Client
+		sourceIndex, sourceType string
+		files                   *fileSource
 	)
-
-	sourcePath := strings.Split(strings.TrimSpace(strings.ReplaceAll(inputUrl.Path, "/", " ")), " ")
-	if len(sourcePath) == 0 {
-		panic("input index name should not be empty")
-	}
-	sourceIndex = sourcePath[0]
-	if len(sourcePath) > 1 {
-		sourceType = sourcePath[1]
-	} else {
-		sourceType = "_doc"
-	}
-
-	targetPath := strings.Split(strings.TrimSpace(strings.ReplaceAll(outputUrl.Path, "/", " ")), " ")
-	if len(targetPath) == 0 {
-		panic("output index name should not be empty")
+	// a file dump is opened and its leftovers are cleaned up before anything is dumped
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	switch {
+	case conf.Input == stdio:
+		// docs piped in _bulk API format, e.g. by another esdump writing to stdout
+		files = &fileSource{store: stdinStore{}, single: stdio, bulk: true}
+		sourceIndex, sourceType = files.index()
+	case isStoreScheme(inputUrl):
+		st, single, err := openInput(ctx, inputUrl, conf)
+		if err != nil {
+			return nil, err
+		}
+		if files, err = newFileSource(ctx, st, single); err != nil {
+			return nil, err
+		}
+		sourceIndex, sourceType = files.index()
+	default:
+		sourceIndex, sourceType = indexAndType(inputUrl)
+		if stringutils.IsEmpty(sourceIndex) {
+			return nil, &ParseError{Field: "input", Value: redactURL(conf.Input), Err: errors.New("index name should not be empty")}
+		}
+		if source, err = newClient(inputUrl); err != nil {
+			return nil, err
+		}
 	}
-	targetIndex = targetPath[0]
-	if len(targetPath) > 1 {
-		targetType = targetPath[1]
-	} else {
-		targetType = "_doc"
+	var (
+		target                  *elastic.Client
+		targetIndex, targetType string
+	)
+	if conf.Output != stdio && !isStoreScheme(outputUrl) {
+		targetIndex, targetType = indexAndType(outputUrl)
+		if stringutils.IsEmpty(targetIndex) {
+			return nil, &ParseError{Field: "output", Value: redactURL(conf.Output), Err: errors.New("index name should not be empty")}
+		}
+		if target, err = newClient(outputUrl); err != nil {
+			return nil, err
+		}
 	}
 
 	var startTime, endTime *time.Time
 	if stringutils.IsNotEmpty(conf.StartDate) {
 		start, err := time.ParseInLocation(constants.FORMAT2, conf.StartDate, time.Local)
 		if err != nil {
-			panic(err)
+			return nil, &ParseError{Field: "start date", Value: conf.StartDate, Err: err}
 		}
 		startTime = &start
 	}
@@ -120,7 +205,7 @@ func NewDumper(conf Config) *Dumper {
 	if stringutils.IsNotEmpty(conf.EndDate) {
 		end, err := time.ParseInLocation(constants.FORMAT2, conf.EndDate, time.Local)
 		if err != nil {
-			panic(err)
+			return nil, &ParseError{Field: "end date", Value: conf.EndDate, Err: err}
 		}
 		endTime = &end
 	}
@@ -129,10 +214,11 @@ func NewDumper(conf Config) *Dumper {
 	if stringutils.IsNotEmpty(conf.Zone) {
 		zone, err = time.LoadLocation(conf.Zone)
 		if err != nil {
-			panic(err)
+			return nil, &ParseError{Field: "zone", Value: conf.Zone, Err: err}
 		}
 	} else {
-		zone = time.Local
+		// elasticsearch takes dates without offset as UTC
+		zone = time.UTC
 	}
 
 	var includes, excludes []string
@@ -142,7 +228,54 @@ func NewDumper(conf Config) *Dumper {
 	if stringutils.IsNotEmpty(conf.Excludes) {
 		excludes = strings.Split(conf.Excludes, ",")
 	}
-	return &Dumper{
+	settings, err := parseSettings(conf.Settings)
+	if err != nil {
+		return nil, err
+	}
+	aliasRenames, err := parsePairs("alias rename", conf.AliasRename)
+	if err != nil {
+		return nil, err
+	}
+	for from, to := range aliasRenames {
+		if to == "" {
+			return nil, &ParseError{Field: "alias rename", Value: conf.AliasRename, Err: errors.Errorf("new name of alias %s should not be empty", from)}
+		}
+	}
+	if stringutils.IsNotEmpty(conf.SwapAlias) {
+		if target == nil {
+			return nil, &ParseError{Field: "swap alias", Value: conf.SwapAlias, Err: errors.New("alias can only be swapped onto an index")}
+		}
+		if conf.DumpType == "mapping" {
+			return nil, &ParseError{Field: "swap alias", Value: conf.SwapAlias, Err: errors.New("alias cannot be swapped onto an index without data")}
+		}
+		if conf.VerifyCount && (startTime != nil || endTime != nil) {
+			return nil, &ParseError{Field: "swap alias", Value: conf.SwapAlias, Err: errors.New("verify count requires dumping all docs without start and end dates")}
+		}
+	}
+	if conf.Pipelines && (source == nil || target == nil) {
+		return nil, &ParseError{Field: "pipelines", Value: "true", Err: errors.New("ingest pipelines can only be copied from an index to an index")}
+	}
+	switch conf.OldIndex {
+	case "", oldIndexKeep, oldIndexClose, oldIndexDelete:
+	default:
+		return nil, &ParseError{Field: "old index", Value: conf.OldIndex, Err: errors.New("old index should be keep, close or delete")}
+	}
+	var checkpoints *CheckpointStore
+	if stringutils.IsNotEmpty(conf.Checkpoint) {
+		checkpoints = NewCheckpointStore(conf.Checkpoint)
+	} else if conf.Resume && !isStoreScheme(outputUrl) {
+		// a file dump resumes from its manifest, other outputs have nothing to resume from
+		return nil, &ParseError{Field: "checkpoint", Value: conf.Checkpoint, Err: errors.New("checkpoint file should be given to resume")}
+	}
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	bulkConcurrency := conf.BulkConcurrency
+	if bulkConcurrency <= 0 {
+		bulkConcurrency = workers
+	}
+	d := &Dumper{
 		Conf:         conf,
 		SourceClient: source,
 		TargetClient: target,
@@ -155,175 +288,269 @@ func NewDumper(conf Config) *Dumper {
 		Zone:         zone,
 		Includes:     includes,
 		Excludes:     excludes,
+		Checkpoints:  checkpoints,
+		bulkSlots:    make(chan struct{}, bulkConcurrency),
+		settings:     settings,
+		aliasRenames: aliasRenames,
+	}
+	if files != nil {
+		files.d = d
+		if stringutils.IsNotEmpty(conf.DateField) {
+			files.dateField = conf.DateField
+		}
+		files.filter = newSourceFilter(includes, excludes)
+		d.source = files
+	} else {
+		d.source = &esSource{d: d}
 	}
+	joiner := conf.CSVJoiner
+	if joiner == "" {
+		joiner = ","
+	}
+	if conf.Compress != "" && !isStoreScheme(outputUrl) {
+		return nil, &ParseError{Field: "compress", Value: conf.Compress, Err: errors.New("only data files of file dumps are compressed")}
+	}
+	switch {
+	case conf.Output == stdio:
+		f, err := parseFormat(conf.Format, formatBulk)
+		if err != nil {
+			return nil, err
+		}
+		if f == formatParquet {
+			return nil, &ParseError{Field: "format", Value: conf.Format, Err: errors.New("Parquet files cannot be written to stdout")}
+		}
+		d.layout = &layout{format: f, joiner: joiner}
+		d.sink = newStreamSink(os.Stdout, d.layout)
+	case isStoreScheme(outputUrl):
+		f, err := parseFormat(conf.Format, formatNDJSON)
+		if err != nil {
+			return nil, err
+		}
+		d.layout = &layout{format: f, joiner: joiner}
+		c, err := parseCodec(conf.Compress, codecOf(outputUrl.Host+outputUrl.Path))
+		if err != nil {
+			return nil, err
+		}
+		if f == formatParquet {
+			// Parquet files compress their pages themselves
+			d.layout.compression, c = c, codecNone
+		}
+		st, err := openStore(outputUrl, conf, true)
+		if err != nil {
+			return nil, err
+		}
+		manifest := Manifest{
+			Index:     sourceIndex,
+			Type:      sourceType,
+			DateField: conf.DateField,
+			Codec:     string(c),
+		}
+		if d.sink, err = newFileSink(ctx, st, d.layout, manifest, conf.Resume); err != nil {
+			return nil, err
+		}
+	default:
+		d.sink = &esSink{d: d}
+	}
+	return d, nil
 }
 
-func (d *Dumper) Dump() {
+// Summary returns what has been dumped by the last call of Dump
+func (d *Dumper) Summary() Summary {
+	return d.summary
+}
+
+// Dump dumps mapping and/or data according to Conf.DumpType, then swaps Conf.SwapAlias onto target index
+// if it is set. If ctx is cancelled, bulk requests in flight are finished and checkpointed before it returns.
+func (d *Dumper) Dump(ctx context.Context) error {
+	var err error
 	switch d.Conf.DumpType {
 	case "mapping":
-		d.dumpMapping()
+		err = d.dumpMapping(ctx)
 	case "data":
-		d.dumpData()
+		err = d.dumpData(ctx)
 	default:
-		d.dumpMapping()
-		d.dumpData()
+		if err = d.dumpMapping(ctx); err == nil {
+			err = d.dumpData(ctx)
+		}
 	}
-}
-
-func (d *Dumper) dumpMapping() {
-	sourceOptions := []esutils.EsOption{esutils.WithClient(d.SourceClient)}
-	if stringutils.IsNotEmpty(d.SourceType) {
-		sourceOptions = append(sourceOptions, esutils.WithType(d.SourceType))
+	if err == nil && ctx.Err() == nil && d.pipelineSettings != nil {
+		err = d.putPipelineSettings(ctx)
 	}
-	targetOptions := []esutils.EsOption{esutils.WithClient(d.TargetClient)}
-	if stringutils.IsNotEmpty(d.TargetType) {
-		targetOptions = append(targetOptions, esutils.WithType(d.TargetType))
+	if err != nil || stringutils.IsEmpty(d.Conf.SwapAlias) {
+		return err
 	}
-	sourceEs := esutils.NewEs(d.SourceIndex, sourceOptions...)
-	targetEs := esutils.NewEs(d.TargetIndex, targetOptions...)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	mapping, err := sourceEs.GetMapping(ctx)
-	if err != nil {
-		panic(err)
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
+	if d.summary.DeadLetters > 0 {
+		// target index misses docs written to the dead letter file
+		return errors.Errorf("alias %s is not swapped as %d docs were rejected by target index", d.Conf.SwapAlias, d.summary.DeadLetters)
+	}
+	return d.swapAlias(ctx)
+}
 
-	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	_, err = targetEs.NewIndexOnly(ctx)
+func (d *Dumper) dumpMapping(ctx context.Context) error {
+	meta, err := d.sourceMeta(ctx)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	target := *meta
+	target.Settings = d.targetSettings(meta.Settings)
+	target.Aliases = d.targetAliases(meta.Aliases)
+	if d.Conf.Pipelines {
+		// pipelines are named by settings of target index, which may have been overridden
+		if err = d.copyPipelines(ctx, target.Settings); err != nil {
+			return err
+		}
+		d.pipelineSettings = splitPipelineSettings(target.Settings)
 	}
-	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	return d.sink.putIndex(ctx, target)
+}
 
-	container := gabs.Wrap(mapping)
-	sourceType := d.SourceType
-	if stringutils.IsEmpty(sourceType) {
-		sourceType = "_doc"
+// sourceMeta returns mapping and settings of source index, they are fetched once and shared by dumpMapping
+// and layouts derived from the mapping
+func (d *Dumper) sourceMeta(ctx context.Context) (*indexMeta, error) {
+	if d.meta != nil {
+		return d.meta, nil
 	}
-	container = container.Path(fmt.Sprintf("%s.mappings.%s", d.SourceIndex, sourceType))
-	data := container.String()
-
-	err = targetEs.PutMappingJson(ctx, data)
+	meta, err := d.source.meta(ctx)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	d.meta = &meta
+	return d.meta, nil
 }
 
-func (d *Dumper) getMinMaxTime() (minTime, maxTime *time.Time) {
-	sourceOptions := []esutils.EsOption{esutils.WithClient(d.SourceClient)}
-	if stringutils.IsNotEmpty(d.SourceType) {
-		sourceOptions = append(sourceOptions, esutils.WithType(d.SourceType))
-	}
-	sourceEs := esutils.NewEs(d.SourceIndex, sourceOptions...)
-	paging := &esutils.Paging{
-		Skip:  0,
-		Limit: 1,
-		Sortby: []esutils.Sort{
-			{
-				Field:     d.Conf.DateField,
-				Ascending: true,
-			},
-		},
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// getMinMaxTime returns min and max date of source docs by min and max aggregations on date field,
+// they are nil if no doc has date field
+func (d *Dumper) getMinMaxTime(ctx context.Context) (minTime, maxTime *time.Time, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	page, err := sourceEs.Page(ctx, paging)
+	result, err := d.SourceClient.Search(d.SourceIndex).Type(d.SourceType).Size(0).
+		Aggregation("min", elastic.NewMinAggregation().Field(d.dateField.Name)).
+		Aggregation("max", elastic.NewMaxAggregation().Field(d.dateField.Name)).
+		Do(ctx)
 	if err != nil {
-		panic(err)
-	}
-	if len(page.Docs) > 0 {
-		first := page.Docs[0].(map[string]interface{})
-		if value, ok := first[d.Conf.DateField]; ok {
-			t, err := dateparse.ParseIn(value.(string), d.Zone)
-			if err != nil {
-				panic(err)
-			}
-			minTime = &t
+		return nil, nil, requestError(err, d.Conf.Input, "get min and max date error")
+	}
+	if min, ok := result.Aggregations.Min("min"); ok && min.Value != nil {
+		t := d.dateField.time(*min.Value)
+		minTime = &t
+	}
+	if max, ok := result.Aggregations.Max("max"); ok && max.Value != nil {
+		t := d.dateField.time(*max.Value)
+		maxTime = &t
+	}
+	return
+}
+
+// timeRange returns [start, end) of docs to dump. Boundaries not given by Conf are detected from source index,
+// and they are narrowed down to the part not committed yet if last checkpoint is given.
+func (d *Dumper) timeRange(ctx context.Context, last *Checkpoint) (start, end time.Time, err error) {
+	if d.StartTime == nil || d.EndTime == nil {
+		var min, max *time.Time
+		if min, max, err = d.getMinMaxTime(ctx); err != nil {
+			return
+		}
+		if min == nil || max == nil {
+			// no doc to dump
+			return
 		}
+		if d.StartTime == nil {
+			start = *min
+		}
+		if d.EndTime == nil {
+			end = max.Add(1 * time.Second)
+		}
+	}
+	if d.StartTime != nil {
+		start = *d.StartTime
+	}
+	if d.EndTime != nil {
+		end = *d.EndTime
 	}
+	start = start.In(time.Local)
+	end = end.In(time.Local)
 
-	paging = &esutils.Paging{
-		Skip:  0,
-		Limit: 1,
-		Sortby: []esutils.Sort{
-			{
-				Field:     d.Conf.DateField,
-				Ascending: false,
-			},
-		},
-	}
-	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	page, err = sourceEs.Page(ctx, paging)
-	if err != nil {
-		panic(err)
-	}
-	if len(page.Docs) > 0 {
-		first := page.Docs[0].(map[string]interface{})
-		if value, ok := first[d.Conf.DateField]; ok {
-			t, err := dateparse.ParseIn(value.(string), d.Zone)
-			if err != nil {
-				panic(err)
+	if last == nil {
+		return
+	}
+	if boundary := last.Boundary(); boundary != nil {
+		if !d.Conf.Descending && boundary.After(start) {
+			start = boundary.In(time.Local)
+		}
+		if d.Conf.Descending && boundary.Before(end) {
+			if last.Cursor != nil {
+				// docs sharing the cursor date may be partially committed, so dump them again. The bound is
+				// exclusive and truncated to precision of the field, e.g. epoch seconds, so it is moved up a whole step.
+				end = boundary.Truncate(d.dateField.precision()).Add(d.dateField.precision()).In(time.Local)
+			} else {
+				end = boundary.In(time.Local)
 			}
-			maxTime = &t
 		}
 	}
-
 	return
 }
 
-func (d *Dumper) dumpData() {
-	sourceOptions := []esutils.EsOption{esutils.WithClient(d.SourceClient)}
-	if stringutils.IsNotEmpty(d.SourceType) {
-		sourceOptions = append(sourceOptions, esutils.WithType(d.SourceType))
-	}
-	targetOptions := []esutils.EsOption{esutils.WithClient(d.TargetClient)}
-	if stringutils.IsNotEmpty(d.TargetType) {
-		targetOptions = append(targetOptions, esutils.WithType(d.TargetType))
-	}
-	sourceEs := esutils.NewEs(d.SourceIndex, sourceOptions...)
-	targetEs := esutils.NewEs(d.TargetIndex, targetOptions...)
-	start := d.StartTime
-	end := d.EndTime
-
-	if start == nil || end == nil {
-		min, max := d.getMinMaxTime()
-		if start == nil {
-			start = min
+func (d *Dumper) dumpData(ctx context.Context) error {
+	checkpoint := Checkpoint{
+		Source:     redactURL(d.Conf.Input),
+		Target:     redactURL(d.Conf.Output),
+		Descending: d.Conf.Descending,
+		DateField:  d.Conf.DateField,
+		StartDate:  d.Conf.StartDate,
+		EndDate:    d.Conf.EndDate,
+		Step:       d.Conf.Step.String(),
+	}
+	var last *Checkpoint
+	if d.Checkpoints != nil && d.Conf.Resume {
+		var err error
+		last, err = d.Checkpoints.Load(checkpoint.Source, checkpoint.Target)
+		if err != nil {
+			return err
 		}
-		if end == nil {
-			fixed := max.Add(1 * time.Second)
-			end = &fixed
+		if last != nil {
+			if last.Descending != d.Conf.Descending {
+				return errors.Errorf("checkpoint of %s was saved with desc=%t, cannot resume with desc=%t", checkpointKey(checkpoint.Source, checkpoint.Target), last.Descending, d.Conf.Descending)
+			}
+			for _, option := range []struct{ name, saved, given string }{
+				{"date", last.DateField, checkpoint.DateField},
+				{"start", last.StartDate, checkpoint.StartDate},
+				{"end", last.EndDate, checkpoint.EndDate},
+				{"step", last.Step, checkpoint.Step},
+			} {
+				if option.saved != option.given {
+					return errors.Errorf("checkpoint of %s was saved with %s=%q, cannot resume with %s=%q", checkpointKey(checkpoint.Source, checkpoint.Target), option.name, option.saved, option.name, option.given)
+				}
+			}
+			checkpoint = *last
+			checkpoint.Completed = false
 		}
 	}
 
-	localStart := start.In(time.Local)
-	start = &localStart
-
-	localEnd := end.In(time.Local)
-	end = &localEnd
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	paging := &esutils.Paging{
-		StartDate: start.Format(constants.FORMAT),
-		EndDate:   end.Format(constants.FORMAT),
-		DateField: d.Conf.DateField,
-		Zone:      time.Local.String(),
-	}
-	if len(d.Includes) > 0 {
-		paging.Includes = d.Includes
-	}
-	if len(d.Excludes) > 0 {
-		paging.Excludes = d.Excludes
-	}
-	total, err := sourceEs.Count(ctx, paging)
+	windows, total, err := d.source.plan(ctx, last)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if d.Conf.Resume {
+		// windows written out by last run are not dumped again even without a checkpoint
+		pending := windows[:0]
+		for _, w := range windows {
+			if docs, ok := d.sink.committed(w); ok {
+				total -= docs
+				continue
+			}
+			pending = append(pending, w)
+		}
+		windows = pending
+		if total < 0 {
+			total = 0
+		}
+	}
+	if d.layout != nil {
+		if err = d.layout.resolve(ctx, d); err != nil {
+			return err
+		}
 	}
 
 	bar := progressbar.NewOptions64(
@@ -334,7 +561,8 @@ func (d *Dumper) dumpData() {
 		progressbar.OptionShowCount(),
 		progressbar.OptionShowIts(),
 		progressbar.OptionOnCompletion(func() {
-			fmt.Printf("\n")
+			// stdout may be the output of docs
+			fmt.Fprintln(os.Stderr)
 		}),
 		progressbar.OptionSpinnerType(14),
 		progressbar.OptionFullWidth(),
@@ -342,81 +570,28 @@ func (d *Dumper) dumpData() {
 		progressbar.OptionEnableColorCodes(true),
 	)
 
-	step := d.Conf.Step
-	if !d.Conf.Descending {
-		for start.Before(*end) {
-			_end := start.Add(step)
-			if _end.After(*end) {
-				_end = *end
-			}
-			paging = &esutils.Paging{
-				StartDate:  start.Format(constants.FORMAT),
-				EndDate:    _end.Format(constants.FORMAT),
-				DateField:  d.Conf.DateField,
-				Limit:      -1,
-				ScrollSize: d.Conf.ScrollSize,
-				Zone:       time.Local.String(),
-			}
-			if len(d.Includes) > 0 {
-				paging.Includes = d.Includes
-			}
-			if len(d.Excludes) > 0 {
-				paging.Excludes = d.Excludes
-			}
-			docs, err := sourceEs.List(context.Background(), paging, func(message json.RawMessage) (interface{}, error) {
-				var p map[string]interface{}
-				if err := json.Unmarshal(message, &p); err != nil {
-					return nil, err
-				}
-				return p, nil
-			})
-			if err != nil {
-				panic(err)
-			}
-			if len(docs) > 0 {
-				err = targetEs.BulkSaveOrUpdate(context.Background(), docs)
-				if err != nil {
-					panic(err)
-				}
-			}
-			start = &_end
-			bar.Add(len(docs))
-		}
-	} else {
-		for end.After(*start) {
-			_start := end.Add(-step)
-			if _start.Before(*start) {
-				_start = *start
-			}
-			paging = &esutils.Paging{
-				StartDate:  _start.Format(constants.FORMAT),
-				EndDate:    end.Format(constants.FORMAT),
-				DateField:  d.Conf.DateField,
-				Limit:      -1,
-				ScrollSize: d.Conf.ScrollSize,
-				Zone:       time.Local.String(),
-			}
-			if len(d.Includes) > 0 {
-				paging.Includes = d.Includes
-			}
-			if len(d.Excludes) > 0 {
-				paging.Excludes = d.Excludes
-			}
-			docs, err := sourceEs.List(context.Background(), paging, func(message json.RawMessage) (interface{}, error) {
-				var p map[string]interface{}
-				if err := json.Unmarshal(message, &p); err != nil {
-					return nil, err
-				}
-				return p, nil
-			})
-			if len(docs) > 0 {
-				err = targetEs.BulkSaveOrUpdate(context.Background(), docs)
-				if err != nil {
-					panic(err)
-				}
-			}
-			end = &_start
-			bar.Add(len(docs))
+	if stringutils.IsNotEmpty(d.Conf.DeadLetter) {
+		if d.deadLetters, err = newDeadLetterWriter(d.Conf.DeadLetter); err != nil {
+			return err
 		}
+		defer func() {
+			d.deadLetters.Close()
+			d.deadLetters = nil
+		}()
+	}
+
+	p := newProgress(bar, d.Checkpoints, checkpoint, windows, d.sink.pagesDurable())
+	begin := time.Now()
+	err = d.copyWindows(ctx, windows, p)
+	d.summary = p.summary()
+	d.summary.Elapsed = time.Since(begin)
+	d.summary.Interrupted = ctx.Err() != nil
+	if d.deadLetters != nil {
+		d.summary.Docs -= d.deadLetters.count
+		d.summary.DeadLetters = d.deadLetters.count
+	}
+	if err != nil {
+		return err
 	}
+	return d.sink.flush(ctx)
 }
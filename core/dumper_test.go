@@ -2,15 +2,32 @@ package core_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/olivere/elastic/v7"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"github.com/unionj-cloud/go-doudou/toolkit/constants"
 	"github.com/wubin1989/esdump/v2/core"
 	"github.com/wubin1989/go-esutils/v2"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -113,6 +130,56 @@ func SetupEs7Container(logger *logrus.Logger) (func(), string, int, error) {
 	return closeContainer, host, port, nil
 }
 
+// SetupMinioContainer starts minio docker container with a bucket
+func SetupMinioContainer(logger *logrus.Logger, bucket string) (func(), string, error) {
+	logger.Info("setup MinIO Container")
+	ctx := context.Background()
+
+	req := testcontainers.ContainerRequest{
+		Image:        "minio/minio:RELEASE.2022-08-02T23-59-16Z",
+		ExposedPorts: []string{"9000/tcp"},
+		Env: map[string]string{
+			"MINIO_ROOT_USER":     "minioadmin",
+			"MINIO_ROOT_PASSWORD": "minioadmin",
+		},
+		Cmd:        []string{"server", "/data"},
+		WaitingFor: wait.ForHTTP("/minio/health/live").WithPort("9000/tcp"),
+	}
+
+	minioC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: req,
+		Started:          true,
+	})
+	if err != nil {
+		logger.Errorf("error starting MinIO container: %s", err)
+		return nil, "", err
+	}
+
+	closeContainer := func() {
+		logger.Info("terminating container")
+		if err := minioC.Terminate(ctx); err != nil {
+			logger.Errorf("error terminating MinIO container: %s", err)
+		}
+	}
+
+	host, _ := minioC.Host(ctx)
+	p, _ := minioC.MappedPort(ctx, "9000/tcp")
+	endpoint := fmt.Sprintf("%s:%d", host, p.Int())
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4("minioadmin", "minioadmin", ""),
+	})
+	if err != nil {
+		closeContainer()
+		return nil, "", err
+	}
+	if err = client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+		closeContainer()
+		return nil, "", err
+	}
+	return closeContainer, endpoint, nil
+}
+
 var esAddr, input string
 
 func TestMain(m *testing.M) {
@@ -189,12 +256,13 @@ func prepareTestData(es *esutils.Es) {
 func TestDumper_DumpMapping(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpmapping"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:    input,
 		Output:   esAddr + "/" + esIndex,
 		DumpType: "mapping",
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -203,10 +271,226 @@ func TestDumper_DumpMapping(t *testing.T) {
 	assert.NotZero(t, ret)
 }
 
+func TestDumper_DumpMappingSettings(t *testing.T) {
+	t.Parallel()
+	sourceIndex := "test_dumpmappingsettings_source"
+	esIndex := "test_dumpmappingsettings"
+	es := esutils.NewEs(sourceIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err := es.NewIndex(context.Background(), `{
+		"settings": {
+			"number_of_replicas": 1,
+			"blocks": {"write": true},
+			"analysis": {
+				"normalizer": {"lower": {"type": "custom", "filter": ["lowercase"]}},
+				"analyzer": {"folding": {"type": "custom", "tokenizer": "standard", "filter": ["lowercase", "asciifolding"]}}
+			}
+		},
+		"mappings": {
+			"_doc": {
+				"properties": {
+					"name": {"type": "keyword", "normalizer": "lower"},
+					"text": {"type": "text", "analyzer": "folding"}
+				}
+			}
+		}
+	}`)
+	require.NoError(t, err)
+	dumper, err := core.NewDumper(core.Config{
+		Input:    esAddr + "/" + sourceIndex,
+		Output:   esAddr + "/" + esIndex,
+		DumpType: "mapping",
+		Settings: "number_of_replicas=0,refresh_interval=5s",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	client, err := elastic.NewSimpleClient(elastic.SetURL(esAddr))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := client.IndexGetSettings(esIndex).FlatSettings(true).Do(ctx)
+	require.NoError(t, err)
+	settings := resp[esIndex].Settings
+	assert.Equal(t, "0", settings["index.number_of_replicas"])
+	assert.Equal(t, "5s", settings["index.refresh_interval"])
+	assert.Equal(t, "custom", settings["index.analysis.analyzer.folding.type"])
+	assert.Equal(t, "custom", settings["index.analysis.normalizer.lower.type"])
+	assert.Equal(t, esIndex, settings["index.provided_name"])
+	assert.NotContains(t, settings, "index.blocks.write")
+	mapping, err := client.GetMapping().Index(esIndex).Do(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, fmt.Sprint(mapping), "folding")
+}
+
+func TestDumper_DumpMappingAliases(t *testing.T) {
+	t.Parallel()
+	sourceIndex := "test_dumpmappingaliases_source"
+	esIndex := "test_dumpmappingaliases"
+	es := esutils.NewEs(sourceIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err := es.NewIndex(context.Background(), `{
+		"aliases": {
+			"aliases_sport": {"filter": {"term": {"type": "sport"}}},
+			"aliases_routed": {"index_routing": "1", "search_routing": "1,2"},
+			"aliases_write": {"is_write_index": true}
+		}
+	}`)
+	require.NoError(t, err)
+	dumper, err := core.NewDumper(core.Config{
+		Input:       esAddr + "/" + sourceIndex,
+		Output:      esAddr + "/" + esIndex,
+		DumpType:    "mapping",
+		Aliases:     true,
+		AliasRename: "aliases_write=aliases_write_v2",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	client, err := elastic.NewSimpleClient(elastic.SetURL(esAddr))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := client.IndexGet(esIndex).Do(ctx)
+	require.NoError(t, err)
+	aliases := resp[esIndex].Aliases
+	assert.Len(t, aliases, 3)
+	assert.Contains(t, fmt.Sprint(aliases["aliases_sport"]), "sport")
+	assert.Equal(t, map[string]interface{}{"index_routing": "1", "search_routing": "1,2"}, aliases["aliases_routed"])
+	assert.Equal(t, map[string]interface{}{"is_write_index": true}, aliases["aliases_write_v2"])
+}
+
+func TestDumper_DumpMappingPipelines(t *testing.T) {
+	t.Parallel()
+	sourceIndex := "test_dumpmappingpipelines_source"
+	esIndex := "test_dumpmappingpipelines"
+	client, err := elastic.NewSimpleClient(elastic.SetURL(esAddr))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for path, body := range map[string]string{
+		"/_scripts/test_pipelines_script":           `{"script": {"lang": "painless", "source": "ctx.runs = ctx.containsKey('runs') ? ctx.runs + 1 : 1"}}`,
+		"/_ingest/pipeline/test_pipelines_scripted": `{"processors": [{"script": {"id": "test_pipelines_script"}}]}`,
+		"/_ingest/pipeline/test_pipelines_default":  `{"processors": [{"pipeline": {"name": "test_pipelines_scripted"}}]}`,
+	} {
+		_, err = client.PerformRequest(ctx, elastic.PerformRequestOptions{Method: "PUT", Path: path, Body: body})
+		require.NoError(t, err)
+	}
+	es := esutils.NewEs(sourceIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err = es.NewIndex(ctx, `{"settings": {"default_pipeline": "test_pipelines_default"}, "mappings": {"properties": {"createAt": {"type": "date"}}}}`)
+	require.NoError(t, err)
+	_, err = client.Index().Index(sourceIndex).Id("1").BodyString(`{"createAt": "2020-06-01T00:00:00Z"}`).Refresh("true").Do(ctx)
+	require.NoError(t, err)
+
+	_, err = core.NewDumper(core.Config{
+		Input:     esAddr + "/" + sourceIndex,
+		Output:    "file:///tmp/test_dumpmappingpipelines",
+		Pipelines: true,
+	})
+	var parseErr *core.ParseError
+	assert.True(t, errors.As(err, &parseErr))
+
+	dumper, err := core.NewDumper(core.Config{
+		Input:     esAddr + "/" + sourceIndex,
+		Output:    esAddr + "/" + esIndex,
+		DateField: "createAt",
+		Step:      240 * time.Hour,
+		Pipelines: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(ctx))
+	// copied docs are not run through the pipelines again
+	doc, err := client.Get().Index(esIndex).Id("1").Do(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, string(doc.Source), `"runs":1`)
+	_, err = client.Index().Index(esIndex).Id("2").BodyString(`{"name": "doc"}`).Refresh("true").Do(ctx)
+	require.NoError(t, err)
+	doc, err = client.Get().Index(esIndex).Id("2").Do(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, string(doc.Source), `"runs":1`)
+}
+
+func TestDumper_DumpSwapAlias(t *testing.T) {
+	t.Parallel()
+	oldIndex := "test_dumpswapalias_old"
+	esIndex := "test_dumpswapalias"
+	alias := "test_dumpswapalias_alias"
+	es := esutils.NewEs(oldIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err := es.NewIndex(context.Background(), `{"aliases": {"`+alias+`": {"is_write_index": true}}}`)
+	require.NoError(t, err)
+	client, err := elastic.NewSimpleClient(elastic.SetURL(esAddr))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// an index without data never takes over the alias
+	_, err = core.NewDumper(core.Config{
+		Input:     input,
+		Output:    esAddr + "/" + esIndex,
+		DumpType:  "mapping",
+		SwapAlias: alias,
+	})
+	var parseErr *core.ParseError
+	assert.True(t, errors.As(err, &parseErr))
+
+	// without count verification, docs rejected into the dead letter file still keep the alias where it is
+	rejectedIndex := esIndex + "_rejected"
+	es = esutils.NewEs(rejectedIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err = es.NewIndex(ctx, esutils.NewMapping(esutils.MappingPayload{
+		Base: esutils.Base{
+			Index: es.GetIndex(),
+			Type:  es.GetType(),
+		},
+		Fields: []esutils.Field{
+			{
+				Name: "createAt",
+				Type: esutils.LONG,
+			},
+		},
+	}))
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "esdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dumper, err := core.NewDumper(core.Config{
+		Input:      input,
+		Output:     esAddr + "/" + rejectedIndex,
+		DumpType:   "data",
+		DateField:  "createAt",
+		Step:       240 * time.Hour,
+		DeadLetter: filepath.Join(dir, "dlq.ndjson"),
+		SwapAlias:  alias,
+		OldIndex:   "delete",
+	})
+	require.NoError(t, err)
+	assert.Error(t, dumper.Dump(ctx))
+	assert.Equal(t, int64(3), dumper.Summary().DeadLetters)
+	aliases, err := client.Aliases().Alias(alias).Do(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldIndex}, aliases.IndicesByAlias(alias))
+
+	dumper, err = core.NewDumper(core.Config{
+		Input:       input,
+		Output:      esAddr + "/" + esIndex,
+		DateField:   "createAt",
+		Step:        240 * time.Hour,
+		SwapAlias:   alias,
+		VerifyCount: true,
+		OldIndex:    "close",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(ctx))
+	resp, err := client.IndexGet(alias).Do(ctx)
+	require.NoError(t, err)
+	require.Len(t, resp, 1)
+	require.Contains(t, resp, esIndex)
+	assert.Equal(t, map[string]interface{}{"is_write_index": true}, resp[esIndex].Aliases[alias])
+	indices, err := client.CatIndices().Index(oldIndex).Do(ctx)
+	require.NoError(t, err)
+	require.Len(t, indices, 1)
+	assert.Equal(t, "close", indices[0].Status)
+}
+
 func TestDumper_DumpData(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpdata"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:     input,
 		Output:    esAddr + "/" + esIndex,
 		DumpType:  "data",
@@ -216,7 +500,8 @@ func TestDumper_DumpData(t *testing.T) {
 		Step:      240 * time.Hour,
 		Zone:      "UTC",
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -228,7 +513,7 @@ func TestDumper_DumpData(t *testing.T) {
 func TestDumper_DumpDataDesc(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpdatadesc"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:      input,
 		Output:     esAddr + "/" + esIndex,
 		DumpType:   "data",
@@ -239,7 +524,8 @@ func TestDumper_DumpDataDesc(t *testing.T) {
 		Zone:       "UTC",
 		Descending: true,
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -251,7 +537,7 @@ func TestDumper_DumpDataDesc(t *testing.T) {
 func TestDumper_DumpData2(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpdata2"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:     input,
 		Output:    esAddr + "/" + esIndex,
 		DumpType:  "data",
@@ -261,7 +547,8 @@ func TestDumper_DumpData2(t *testing.T) {
 		Step:      240 * time.Hour,
 		Zone:      "UTC",
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -273,7 +560,7 @@ func TestDumper_DumpData2(t *testing.T) {
 func TestDumper_DumpDataIncludes(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpdataincludes"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:     input,
 		Output:    esAddr + "/" + esIndex,
 		DumpType:  "data",
@@ -284,7 +571,8 @@ func TestDumper_DumpDataIncludes(t *testing.T) {
 		Zone:      "UTC",
 		Includes:  "id,text",
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -310,7 +598,7 @@ func TestDumper_DumpDataIncludes(t *testing.T) {
 func TestDumper_DumpDataExcludes(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpdataexcludes"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:     input,
 		Output:    esAddr + "/" + esIndex,
 		DumpType:  "data",
@@ -321,7 +609,8 @@ func TestDumper_DumpDataExcludes(t *testing.T) {
 		Zone:      "UTC",
 		Excludes:  "text",
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -347,7 +636,7 @@ func TestDumper_DumpDataExcludes(t *testing.T) {
 func TestDumper_DumpDataIncludesDesc(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpdataincludesdesc"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:      input,
 		Output:     esAddr + "/" + esIndex,
 		DumpType:   "data",
@@ -359,7 +648,8 @@ func TestDumper_DumpDataIncludesDesc(t *testing.T) {
 		Includes:   "id,text",
 		Descending: true,
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -385,7 +675,7 @@ func TestDumper_DumpDataIncludesDesc(t *testing.T) {
 func TestDumper_DumpDataExcludesDesc(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpdataexcludesdesc"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:      input,
 		Output:     esAddr + "/" + esIndex,
 		DumpType:   "data",
@@ -397,7 +687,8 @@ func TestDumper_DumpDataExcludesDesc(t *testing.T) {
 		Excludes:   "text",
 		Descending: true,
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -423,7 +714,7 @@ func TestDumper_DumpDataExcludesDesc(t *testing.T) {
 func TestDumper_DumpAll(t *testing.T) {
 	t.Parallel()
 	esIndex := "test_dumpall"
-	dumper := core.NewDumper(core.Config{
+	dumper, err := core.NewDumper(core.Config{
 		Input:     input,
 		Output:    esAddr + "/" + esIndex,
 		DateField: "createAt",
@@ -432,7 +723,967 @@ func TestDumper_DumpAll(t *testing.T) {
 		Step:      240 * time.Hour,
 		Zone:      "UTC",
 	})
-	dumper.Dump()
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpDataKeepID(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdatakeepid"
+	conf := core.Config{
+		Input:     input,
+		Output:    esAddr + "/" + esIndex,
+		DumpType:  "data",
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		EndDate:   "",
+		Step:      240 * time.Hour,
+		Zone:      "UTC",
+	}
+	for i := 0; i < 2; i++ {
+		dumper, err := core.NewDumper(conf)
+		require.NoError(t, err)
+		require.NoError(t, dumper.Dump(context.Background()))
+	}
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	doc, err := es.GetByID(ctx, "9seTXHoBNx091WJ2QCh5")
+	assert.NoError(t, err)
+	assert.Equal(t, "education", doc["type"])
+}
+
+func TestDumper_DumpDataResume(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdataresume"
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	windowStart, _ := time.ParseInLocation(constants.FORMAT2, "2020-06-01", time.Local)
+	windowEnd, _ := time.ParseInLocation(constants.FORMAT2, "2020-06-15", time.Local)
+	assert.NoError(t, core.NewCheckpointStore(checkpointFile).Save(core.Checkpoint{
+		Source:      input,
+		Target:      esAddr + "/" + esIndex,
+		DateField:   "createAt",
+		StartDate:   "2020-06-01",
+		Step:        (240 * time.Hour).String(),
+		WindowStart: &windowStart,
+		WindowEnd:   &windowEnd,
+	}))
+	conf := core.Config{
+		Input:      input,
+		Output:     esAddr + "/" + esIndex,
+		DumpType:   "data",
+		DateField:  "createAt",
+		StartDate:  "2020-06-01",
+		EndDate:    "",
+		Step:       24 * time.Hour,
+		Zone:       "UTC",
+		Checkpoint: checkpointFile,
+		Resume:     true,
+	}
+	// windows of another step do not line up with the checkpoint
+	dumper, err := core.NewDumper(conf)
+	require.NoError(t, err)
+	assert.Error(t, dumper.Dump(context.Background()))
+
+	conf.Step = 240 * time.Hour
+	dumper, err = core.NewDumper(conf)
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, int(ret))
+	checkpoint, err := core.NewCheckpointStore(checkpointFile).Load(input, esAddr+"/"+esIndex)
+	assert.NoError(t, err)
+	assert.NotNil(t, checkpoint)
+	assert.Equal(t, int64(2), checkpoint.Docs)
+}
+
+func TestDumper_DumpDataPages(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdatapages"
+	target, err := url.Parse(esAddr)
+	require.NoError(t, err)
+	// the proxy counts bulk requests sent to target elasticsearch
+	var bulks int64
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			atomic.AddInt64(&bulks, 1)
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	dumper, err := core.NewDumper(core.Config{
+		Input:      input,
+		Output:     server.URL + "/" + esIndex,
+		DumpType:   "data",
+		ScrollSize: 1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	// the only window holds 3 docs, each page of the scroll is flushed by its own bulk request
+	assert.Equal(t, 1, dumper.Summary().TotalWindows)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&bulks))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpDataWorkers(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdataworkers"
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    esAddr + "/" + esIndex,
+		DumpType:  "data",
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		EndDate:   "",
+		Step:      24 * time.Hour,
+		Zone:      "UTC",
+		Workers:   4,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpDataSlices(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdataslices"
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    esAddr + "/" + esIndex,
+		DumpType:  "data",
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		EndDate:   "",
+		Step:      720 * time.Hour,
+		Zone:      "UTC",
+		Slices:    2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpDataWithoutDate(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdatawithoutdate"
+	dumper, err := core.NewDumper(core.Config{
+		Input:    input,
+		Output:   esAddr + "/" + esIndex,
+		DumpType: "data",
+		Zone:     "UTC",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpDataEpochMillis(t *testing.T) {
+	t.Parallel()
+	sourceIndex := "test_epochmillis"
+	es := esutils.NewEs(sourceIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err := es.NewIndex(context.Background(), esutils.NewMapping(esutils.MappingPayload{
+		Base: esutils.Base{
+			Index: es.GetIndex(),
+			Type:  es.GetType(),
+		},
+		Fields: []esutils.Field{
+			{
+				Name: "createAt",
+				Type: esutils.LONG,
+			},
+		},
+	}))
+	assert.NoError(t, err)
+	var docs []interface{}
+	for i, date := range []string{"2020-06-01", "2020-06-20", "2020-07-10"} {
+		createAt, _ := time.ParseInLocation(constants.FORMAT2, date, time.Local)
+		docs = append(docs, map[string]interface{}{
+			"id":       fmt.Sprintf("epoch%d", i),
+			"createAt": createAt.UnixNano() / int64(time.Millisecond),
+		})
+	}
+	assert.NoError(t, es.BulkSaveOrUpdate(context.Background(), docs))
+
+	esIndex := "test_dumpdataepochmillis"
+	dumper, err := core.NewDumper(core.Config{
+		Input:     esAddr + "/" + sourceIndex,
+		Output:    esAddr + "/" + esIndex,
+		DumpType:  "data",
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		EndDate:   "2020-07-01",
+		Step:      240 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es = esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, int(ret))
+}
+
+func TestDumper_DumpDataResumeEpochSeconds(t *testing.T) {
+	t.Parallel()
+	sourceIndex := "test_resumeepochseconds"
+	es := esutils.NewEs(sourceIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err := es.NewIndex(context.Background(), esutils.NewMapping(esutils.MappingPayload{
+		Base: esutils.Base{
+			Index: es.GetIndex(),
+			Type:  es.GetType(),
+		},
+		Fields: []esutils.Field{
+			{
+				Name: "createAt",
+				Type: esutils.LONG,
+			},
+		},
+	}))
+	assert.NoError(t, err)
+	cursor := time.Date(2020, 6, 10, 12, 0, 0, 0, time.Local)
+	var docs []interface{}
+	for i, createAt := range []time.Time{cursor, cursor, cursor.Add(-48 * time.Hour), cursor.Add(time.Second)} {
+		docs = append(docs, map[string]interface{}{
+			"id":       fmt.Sprintf("seconds%d", i),
+			"createAt": createAt.Unix(),
+		})
+	}
+	assert.NoError(t, es.BulkSaveOrUpdate(context.Background(), docs))
+
+	esIndex := "test_dumpdataresumeepochseconds"
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+	// the run died after committing some of the docs dated at the cursor second
+	assert.NoError(t, core.NewCheckpointStore(checkpointFile).Save(core.Checkpoint{
+		Source:     esAddr + "/" + sourceIndex,
+		Target:     esAddr + "/" + esIndex,
+		Descending: true,
+		DateField:  "createAt",
+		StartDate:  "2020-06-01",
+		EndDate:    "2020-07-01",
+		Step:       (240 * time.Hour).String(),
+		Cursor:     &cursor,
+	}))
+	dumper, err := core.NewDumper(core.Config{
+		Input:      esAddr + "/" + sourceIndex,
+		Output:     esAddr + "/" + esIndex,
+		DumpType:   "data",
+		DateField:  "createAt",
+		DateUnit:   "s",
+		StartDate:  "2020-06-01",
+		EndDate:    "2020-07-01",
+		Step:       240 * time.Hour,
+		Descending: true,
+		Checkpoint: checkpointFile,
+		Resume:     true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es = esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	// docs dated at the cursor second are dumped again, later ones had been committed
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestMetaCopier_Plan(t *testing.T) {
+	t.Parallel()
+	client, err := elastic.NewSimpleClient(elastic.SetURL(esAddr))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err = client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "PUT",
+		Path:   "/_component_template/test_meta_component",
+		Body:   `{"template": {"settings": {"number_of_shards": 1}}}`,
+	})
+	require.NoError(t, err)
+	_, err = client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "PUT",
+		Path:   "/_index_template/test_meta_template",
+		Body:   `{"index_patterns": ["test_meta-*"], "composed_of": ["test_meta_component"]}`,
+	})
+	require.NoError(t, err)
+
+	_, err = core.NewMetaCopier(core.MetaConfig{Input: esAddr, Output: esAddr, Kinds: "pipeline"})
+	var parseErr *core.ParseError
+	assert.True(t, errors.As(err, &parseErr))
+
+	// both sides are the same cluster, so there is nothing to change
+	copier, err := core.NewMetaCopier(core.MetaConfig{Input: esAddr, Output: esAddr, Patterns: "test_meta_*"})
+	require.NoError(t, err)
+	changes, err := copier.Plan(ctx)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "component_template", changes[0].Kind)
+	assert.Equal(t, "test_meta_component", changes[0].Name)
+	assert.Equal(t, "index_template", changes[1].Kind)
+	for _, change := range changes {
+		assert.Equal(t, core.MetaUnchanged, change.Action)
+		assert.Empty(t, change.Diff)
+	}
+	assert.NoError(t, copier.Apply(ctx, changes))
+}
+
+func TestDumpIndices(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for i, name := range []string{"test_dumpindices_a", "test_dumpindices_b"} {
+		es := esutils.NewEs(name, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+		docs := make([]interface{}, i+1)
+		for j := range docs {
+			docs[j] = map[string]interface{}{"id": fmt.Sprintf("%s-%d", name, j), "type": "sport"}
+		}
+		require.NoError(t, es.BulkSaveOrUpdate(ctx, docs))
+	}
+	assert.True(t, core.IsIndexPattern(esAddr+"/test_dumpindices_*"))
+	assert.False(t, core.IsIndexPattern(input))
+
+	var started []string
+	reports, err := core.DumpIndices(ctx, core.Config{
+		Input:  esAddr + "/test_dumpindices_*",
+		Output: esAddr + "/{index}-v2",
+	}, func(i, total int, report core.IndexReport) {
+		assert.Equal(t, 2, total)
+		started = append(started, report.Index)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test_dumpindices_a", "test_dumpindices_b"}, started)
+	require.Len(t, reports, 2)
+	for i, report := range reports {
+		assert.NoError(t, report.Err)
+		assert.Equal(t, int64(i+1), report.Summary.Docs)
+		es := esutils.NewEs(report.Index+"-v2", esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+		count, err := es.Count(ctx, nil)
+		require.NoError(t, err)
+		assert.Equal(t, i+1, int(count))
+	}
+
+	_, err = core.DumpIndices(ctx, core.Config{
+		Input:  esAddr + "/test_dumpindices_*",
+		Output: "file:///tmp/test_dumpindices",
+	}, nil)
+	var parseErr *core.ParseError
+	assert.True(t, errors.As(err, &parseErr))
+}
+
+func TestNewDumper_ParseError(t *testing.T) {
+	t.Parallel()
+	_, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    esAddr + "/test_parseerror",
+		DateField: "createAt",
+		StartDate: "2020/06/01",
+	})
+	var parseErr *core.ParseError
+	assert.True(t, errors.As(err, &parseErr))
+}
+
+func TestDumper_DumpMappingError(t *testing.T) {
+	t.Parallel()
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    esAddr + "/test_dumpmappingerror",
+		DumpType:  "data",
+		DateField: "notexists",
+	})
+	require.NoError(t, err)
+	var mappingErr *core.MappingError
+	assert.True(t, errors.As(dumper.Dump(context.Background()), &mappingErr))
+}
+
+func TestDumper_DumpDataCancelled(t *testing.T) {
+	t.Parallel()
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    esAddr + "/test_dumpdatacancelled",
+		DumpType:  "data",
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		Step:      240 * time.Hour,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = dumper.Dump(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Zero(t, dumper.Summary().Docs)
+}
+
+func TestDumper_DumpDataRejected(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdatarejected"
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err := es.NewIndex(context.Background(), esutils.NewMapping(esutils.MappingPayload{
+		Base: esutils.Base{
+			Index: es.GetIndex(),
+			Type:  es.GetType(),
+		},
+		Fields: []esutils.Field{
+			{
+				Name: "createAt",
+				Type: esutils.LONG,
+			},
+		},
+	}))
+	require.NoError(t, err)
+	dumper, err := core.NewDumper(core.Config{
+		Input:        input,
+		Output:       esAddr + "/" + esIndex,
+		DumpType:     "data",
+		DateField:    "createAt",
+		StartDate:    "2020-06-01",
+		Step:         240 * time.Hour,
+		Retries:      2,
+		RetryBackoff: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	var bulkErr *core.BulkError
+	require.True(t, errors.As(dumper.Dump(context.Background()), &bulkErr))
+	require.Len(t, bulkErr.Failed, 1)
+	assert.Equal(t, "9seTXHoBNx091WJ2QCh5", bulkErr.Failed[0].Id)
+	assert.Equal(t, 400, bulkErr.Failed[0].Status)
+}
+
+func TestDumper_DumpDataDeadLetter(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpdatadeadletter"
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	_, err := es.NewIndex(context.Background(), esutils.NewMapping(esutils.MappingPayload{
+		Base: esutils.Base{
+			Index: es.GetIndex(),
+			Type:  es.GetType(),
+		},
+		Fields: []esutils.Field{
+			{
+				Name: "createAt",
+				Type: esutils.LONG,
+			},
+		},
+	}))
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "esdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	deadLetterFile := filepath.Join(dir, "dlq.ndjson")
+	dumper, err := core.NewDumper(core.Config{
+		Input:      input,
+		Output:     esAddr + "/" + esIndex,
+		DumpType:   "data",
+		DateField:  "createAt",
+		StartDate:  "2020-06-01",
+		Step:       240 * time.Hour,
+		DeadLetter: deadLetterFile,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	assert.Equal(t, int64(3), dumper.Summary().DeadLetters)
+	assert.Equal(t, int64(0), dumper.Summary().Docs)
+
+	var letters []core.DeadLetter
+	require.NoError(t, core.ReadDeadLetters(deadLetterFile, func(letter core.DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}))
+	require.Len(t, letters, 3)
+	assert.Equal(t, "test", letters[0].Index)
+	assert.Equal(t, 400, letters[0].Status)
+	assert.NotEmpty(t, letters[0].Reason)
+
+	replayIndex := "test_replaydeadletter"
+	summary, err := core.ReplayDeadLetters(context.Background(), deadLetterFile, core.Config{
+		Output: esAddr + "/" + replayIndex,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Docs)
+	es = esutils.NewEs(replayIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpFile(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    "file://" + filepath.ToSlash(dir),
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		Step:      240 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err := core.ReadManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "test", manifest.Index)
+	assert.Equal(t, "mapping.json", manifest.Mapping)
+	assert.Equal(t, "settings.json", manifest.Settings)
+	assert.Len(t, manifest.Files, 4)
+	var docs int64
+	for _, file := range manifest.Files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name))
+		require.NoError(t, err)
+		assert.Equal(t, file.Docs, int64(strings.Count(string(data), "\n")))
+		assert.NotNil(t, file.Start)
+		assert.NotNil(t, file.End)
+		docs += file.Docs
+	}
+	assert.Equal(t, int64(3), docs)
+	mapping, err := ioutil.ReadFile(filepath.Join(dir, "mapping.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(mapping), "createAt")
+}
+
+func TestDumper_DumpFileResume(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := core.Config{
+		Input:     input,
+		Output:    "file://" + filepath.ToSlash(dir),
+		DumpType:  "data",
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		Step:      240 * time.Hour,
+	}
+	dumper, err := core.NewDumper(conf)
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err := core.ReadManifest(dir)
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 4)
+
+	// the listed file is left as it is, the file missing in the manifest is dumped again
+	kept, dropped := manifest.Files[0], manifest.Files[len(manifest.Files)-1]
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, kept.Name), []byte("kept\n"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, dropped.Name)))
+	manifest.Files = manifest.Files[:len(manifest.Files)-1]
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "manifest.json"), data, 0644))
+
+	conf.Resume = true
+	dumper, err = core.NewDumper(conf)
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err = core.ReadManifest(dir)
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 4)
+	assert.Equal(t, dropped, manifest.Files[3])
+	data, err = ioutil.ReadFile(filepath.Join(dir, kept.Name))
+	require.NoError(t, err)
+	assert.Equal(t, "kept\n", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, dropped.Name))
+	require.NoError(t, err)
+	assert.Equal(t, dropped.Docs, int64(strings.Count(string(data), "\n")))
+}
+
+func TestDumper_ImportFile(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_importfile"
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    "file://" + filepath.ToSlash(dir),
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		Step:      240 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+
+	dumper, err = core.NewDumper(core.Config{
+		Input:     "file://" + filepath.ToSlash(dir),
+		Output:    esAddr + "/" + esIndex,
+		StartDate: "2020-06-15",
+		Includes:  "createAt,type",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, int(ret))
+	doc, err := es.GetByID(ctx, "9seTXHoBNx091WJ2QCh6")
+	assert.NoError(t, err)
+	assert.NotContains(t, doc, "text")
+}
+
+func TestDumper_DumpFileCompressed(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumpfilecompressed"
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "test.zst")
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    "file://" + filepath.ToSlash(output),
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		Step:      240 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err := core.ReadManifest(output)
+	require.NoError(t, err)
+	assert.Equal(t, "zstd", manifest.Codec)
+	for _, file := range manifest.Files {
+		assert.True(t, strings.HasSuffix(file.Name, ".ndjson.zst"))
+	}
+
+	dumper, err = core.NewDumper(core.Config{
+		Input:  "file://" + filepath.ToSlash(output),
+		Output: esAddr + "/" + esIndex,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpFileCompress(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dumper, err := core.NewDumper(core.Config{
+		Input:    input,
+		Output:   "file://" + filepath.ToSlash(dir),
+		DumpType: "data",
+		Compress: "gzip",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err := core.ReadManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", manifest.Codec)
+	require.Len(t, manifest.Files, 1)
+	assert.Equal(t, "data.ndjson.gz", manifest.Files[0].Name)
+
+	// unknown codecs and outputs other than file dumps are refused
+	_, err = core.NewDumper(core.Config{
+		Input:    input,
+		Output:   "file://" + filepath.ToSlash(filepath.Join(dir, "test.zst")),
+		Compress: "lz4",
+	})
+	var parseErr *core.ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	_, err = core.NewDumper(core.Config{
+		Input:    input,
+		Output:   esAddr + "/test_dumpfilecompress",
+		Compress: "gzip",
+	})
+	assert.True(t, errors.As(err, &parseErr))
+}
+
+func TestDumper_DumpS3(t *testing.T) {
+	t.Parallel()
+	esIndex := "test_dumps3"
+	terminate, endpoint, err := SetupMinioContainer(logrus.New(), "dumps")
+	require.NoError(t, err)
+	defer terminate()
+	output := fmt.Sprintf("s3://minioadmin:minioadmin@dumps/test.gz?endpoint=%s&insecure=true", endpoint)
+	client, err := minio.NewCore(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4("minioadmin", "minioadmin", ""),
+	})
+	require.NoError(t, err)
+	// an upload of another key in the bucket is not ours to abort
+	uploadID, err := client.NewMultipartUpload(context.Background(), "dumps", "test.gz/other.part", minio.PutObjectOptions{})
+	require.NoError(t, err)
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    output,
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		Step:      240 * time.Hour,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	uploads, err := client.ListMultipartUploads(context.Background(), "dumps", "test.gz/", "", "", "", 1000)
+	require.NoError(t, err)
+	require.Len(t, uploads.Uploads, 1)
+	assert.Equal(t, uploadID, uploads.Uploads[0].UploadID)
+
+	dumper, err = core.NewDumper(core.Config{
+		Input:  output,
+		Output: esAddr + "/" + esIndex,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpFileBulk(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dumper, err := core.NewDumper(core.Config{
+		Input:    input,
+		Output:   "file://" + filepath.ToSlash(dir),
+		DumpType: "data",
+		Format:   "bulk",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err := core.ReadManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "bulk", manifest.Format)
+	require.Len(t, manifest.Files, 1)
+	assert.Equal(t, "data.bulk", manifest.Files[0].Name)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "data.bulk"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 6)
+	assert.Contains(t, lines, `{"index":{"_index":"test","_id":"9seTXHoBNx091WJ2QCh6"}}`)
+
+	esIndex := "test_dumpfilebulk"
+	dumper, err = core.NewDumper(core.Config{
+		Input:  "file://" + filepath.ToSlash(filepath.Join(dir, "data.bulk")),
+		Output: esAddr + "/" + esIndex,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ret, err := es.Count(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(ret))
+}
+
+func TestDumper_DumpFileCSV(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dumper, err := core.NewDumper(core.Config{
+		Input:    input,
+		Output:   "file://" + filepath.ToSlash(dir),
+		DumpType: "data",
+		Format:   "csv",
+		Excludes: "text",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "data.csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "_id,createAt,id,type", lines[0])
+
+	dir, err = ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dumper, err = core.NewDumper(core.Config{
+		Input:     input,
+		Output:    "file://" + filepath.ToSlash(dir),
+		DumpType:  "data",
+		Format:    "csv",
+		Includes:  "type,_id",
+		DateField: "createAt",
+		StartDate: "2020-06-15",
+		EndDate:   "2020-06-25",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err := core.ReadManifest(dir)
+	require.NoError(t, err)
+	require.Len(t, manifest.Files, 1)
+	data, err = ioutil.ReadFile(filepath.Join(dir, manifest.Files[0].Name))
+	require.NoError(t, err)
+	assert.Equal(t, "type,_id\nsport,9seTXHoBNx091WJ2QCh6\n", string(data))
+}
+
+// parquetFile reads a local Parquet file by parquet-go
+type parquetFile struct {
+	*os.File
+}
+
+func (f parquetFile) Open(name string) (source.ParquetFile, error) {
+	file, err := os.Open(f.Name())
+	return parquetFile{file}, err
+}
+
+func (f parquetFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("read only")
+}
+
+func TestDumper_DumpFileParquet(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "esdump")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "test.zst")
+	dumper, err := core.NewDumper(core.Config{
+		Input:     input,
+		Output:    "file://" + filepath.ToSlash(output),
+		DateField: "createAt",
+		StartDate: "2020-06-01",
+		Step:      240 * time.Hour,
+		Format:    "parquet",
+		Excludes:  "text",
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
+	manifest, err := core.ReadManifest(output)
+	require.NoError(t, err)
+	assert.Equal(t, "parquet", manifest.Format)
+	assert.Empty(t, manifest.Codec)
+	var rows int64
+	var createAts []interface{}
+	var types []interface{}
+	for _, file := range manifest.Files {
+		assert.True(t, strings.HasSuffix(file.Name, ".parquet"))
+		f, err := os.Open(filepath.Join(output, file.Name))
+		require.NoError(t, err)
+		pr, err := reader.NewParquetReader(parquetFile{f}, nil, 1)
+		require.NoError(t, err)
+		assert.Equal(t, file.Docs, pr.GetNumRows())
+		if file.Docs > 0 {
+			assert.Equal(t, parquet.CompressionCodec_ZSTD, pr.Footer.RowGroups[0].Columns[0].MetaData.Codec)
+		}
+		// the root is followed by leaf columns in name order, names of the file are kept as ExName by the reader
+		columns := make(map[string]int64)
+		for i, element := range pr.Footer.Schema[1:] {
+			name := pr.SchemaHandler.Infos[i+1].ExName
+			columns[name] = int64(i)
+			switch name {
+			case "createAt":
+				assert.Equal(t, parquet.Type_INT64, element.GetType())
+				assert.Equal(t, parquet.ConvertedType_TIMESTAMP_MILLIS, element.GetConvertedType())
+			case "type":
+				assert.Equal(t, parquet.Type_BYTE_ARRAY, element.GetType())
+				assert.Equal(t, parquet.ConvertedType_UTF8, element.GetConvertedType())
+			}
+		}
+		require.Contains(t, columns, "createAt")
+		require.Contains(t, columns, "type")
+		values, _, _, err := pr.ReadColumnByIndex(columns["createAt"], pr.GetNumRows())
+		require.NoError(t, err)
+		createAts = append(createAts, values...)
+		values, _, _, err = pr.ReadColumnByIndex(columns["type"], pr.GetNumRows())
+		require.NoError(t, err)
+		types = append(types, values...)
+		rows += pr.GetNumRows()
+		pr.ReadStop()
+		f.Close()
+	}
+	assert.Equal(t, int64(3), rows)
+	var expected []interface{}
+	for _, date := range []string{"2020-06-01", "2020-06-20", "2020-07-10"} {
+		createAt, _ := time.ParseInLocation(constants.FORMAT2, date, time.Local)
+		expected = append(expected, createAt.UnixNano()/int64(time.Millisecond))
+	}
+	assert.ElementsMatch(t, expected, createAts)
+	assert.ElementsMatch(t, []interface{}{"education", "sport", "culture"}, types)
+}
+
+// TestDumper_Stdio is not parallel as it replaces os.Stdout and os.Stdin
+func TestDumper_Stdio(t *testing.T) {
+	esIndex := "test_stdio"
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	dumper, err := core.NewDumper(core.Config{
+		Input:    input,
+		Output:   "-",
+		DumpType: "data",
+	})
+	if err == nil {
+		err = dumper.Dump(context.Background())
+	}
+	w.Close()
+	os.Stdout = stdout
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, 6, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), `{"index":{"_index":"test","_id":"9seTXHoBNx091WJ2QCh6"}}`)
+
+	r, w, err = os.Pipe()
+	require.NoError(t, err)
+	go func() {
+		w.Write(data)
+		w.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+	}()
+	dumper, err = core.NewDumper(core.Config{
+		Input:  "-",
+		Output: esAddr + "/" + esIndex,
+	})
+	require.NoError(t, err)
+	require.NoError(t, dumper.Dump(context.Background()))
 	es := esutils.NewEs(esIndex, esutils.WithLogger(logrus.StandardLogger()), esutils.WithUrls([]string{esAddr}))
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
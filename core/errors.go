@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"net"
+)
+
+// ParseError is returned if a value of Config cannot be parsed
+type ParseError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("cannot parse %s %q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectionError is returned if an elasticsearch cluster cannot be reached
+type ConnectionError struct {
+	URL string
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("cannot connect to %s: %v", e.URL, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// MappingError is returned if mapping of an index cannot be read or applied, e.g. target index has a conflicting mapping
+type MappingError struct {
+	Index string
+	Err   error
+}
+
+func (e *MappingError) Error() string {
+	return fmt.Sprintf("mapping of index %s: %v", e.Index, e.Err)
+}
+
+func (e *MappingError) Unwrap() error {
+	return e.Err
+}
+
+// BulkError is returned if docs are rejected by target index
+type BulkError struct {
+	Index string
+	// Failed are items of bulk response which failed
+	Failed []*elastic.BulkResponseItem
+	Err    error
+}
+
+func (e *BulkError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("bulk into index %s: %v", e.Index, e.Err)
+	}
+	first := e.Failed[0]
+	reason := ""
+	if first.Error != nil {
+		reason = fmt.Sprintf("%s: %s", first.Error.Type, first.Error.Reason)
+	}
+	return fmt.Sprintf("bulk into index %s: %d docs rejected, first is doc %s: %s", e.Index, len(e.Failed), first.Id, reason)
+}
+
+func (e *BulkError) Unwrap() error {
+	return e.Err
+}
+
+// requestError wraps err returned by a request to the cluster at rawURL, failures to reach the cluster are returned
+// as ConnectionError. Timeouts are not, as the cluster has been reached but is too slow to answer.
+func requestError(err error, rawURL string, message string) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return errors.Wrap(err, message)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errors.Wrap(err, message)
+	}
+	var opErr *net.OpError
+	if elastic.IsConnErr(err) || errors.As(err, &opErr) {
+		return &ConnectionError{URL: redactURL(rawURL), Err: err}
+	}
+	return errors.Wrap(err, message)
+}
+
+func isConnectionError(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr)
+}
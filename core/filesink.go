@@ -0,0 +1,318 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	manifestFile = "manifest.json"
+	mappingFile  = "mapping.json"
+	settingsFile = "settings.json"
+	aliasesFile  = "aliases.json"
+)
+
+// Manifest describes the files of a dump directory
+type Manifest struct {
+	// Index and Type are the source index and type
+	Index     string `json:"index"`
+	Type      string `json:"type"`
+	DateField string `json:"dateField,omitempty"`
+	// Mapping and Settings are names of the files holding mapping and settings of source index,
+	// empty if only data has been dumped
+	Mapping  string `json:"mapping,omitempty"`
+	Settings string `json:"settings,omitempty"`
+	// Aliases is name of the file holding aliases of source index, empty unless aliases have been dumped
+	Aliases string `json:"aliases,omitempty"`
+	// Codec is how data files are compressed, "gzip", "zstd" or empty
+	Codec string `json:"codec,omitempty"`
+	// Format is how docs are laid out in data files, "ndjson" or "bulk", empty means ndjson
+	Format string `json:"format,omitempty"`
+	// Files are data files in window order, only committed windows are listed
+	Files     []ManifestFile `json:"files"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// ManifestFile is a data file holding docs of one time window
+type ManifestFile struct {
+	Name string `json:"name"`
+	// Start and End are bounds of the window, nil if the file holds all docs of the index
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+	Docs  int64      `json:"docs"`
+}
+
+// Doc is one line of NDJSON data files, metadata of the source doc is kept along with its source
+type Doc struct {
+	Id      string          `json:"_id,omitempty"`
+	Index   string          `json:"_index,omitempty"`
+	Type    string          `json:"_type,omitempty"`
+	Routing string          `json:"_routing,omitempty"`
+	Source  json.RawMessage `json:"_source"`
+}
+
+// fileSink writes mapping, settings and docs into a store. Docs of each window are written into a file which
+// appears and is listed in the manifest only after the window is committed, so the manifest never lists
+// a partially written file.
+type fileSink struct {
+	store    store
+	layout   *layout
+	mu       sync.Mutex
+	manifest Manifest
+}
+
+// newFileSink cleans up files left by interrupted runs. If resume is true, files listed in the manifest
+// saved by last run are kept in the manifest.
+func newFileSink(ctx context.Context, st store, l *layout, manifest Manifest, resume bool) (*fileSink, error) {
+	if err := st.cleanup(ctx); err != nil {
+		return nil, err
+	}
+	manifest.Format = string(l.format)
+	s := &fileSink{
+		store:    st,
+		layout:   l,
+		manifest: manifest,
+	}
+	if resume {
+		last, err := readManifest(ctx, st)
+		if err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return nil, err
+		}
+		if last != nil {
+			if last.Codec != manifest.Codec || last.format() != s.manifest.format() {
+				return nil, errors.Errorf("cannot resume dump at %s written with codec %q and format %q, got codec %q and format %q",
+					st.location(""), last.Codec, last.format(), manifest.Codec, s.manifest.format())
+			}
+			s.manifest.Mapping = last.Mapping
+			s.manifest.Settings = last.Settings
+			s.manifest.Aliases = last.Aliases
+			s.manifest.Files = last.Files
+		}
+	}
+	return s, nil
+}
+
+// ReadManifest reads the manifest of the dump directory dir
+func ReadManifest(dir string) (*Manifest, error) {
+	return readManifest(context.Background(), &localStore{dir: dir})
+}
+
+func readManifest(ctx context.Context, st store) (*Manifest, error) {
+	var manifest Manifest
+	if err := readJSONFile(ctx, st, manifestFile, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func readJSONFile(ctx context.Context, st store, name string, v interface{}) error {
+	data, err := st.readFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return &ParseError{Field: "file", Value: st.location(name), Err: err}
+	}
+	return nil
+}
+
+func writeJSONFile(ctx context.Context, st store, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "call MarshalIndent() error")
+	}
+	return st.writeFile(ctx, name, data)
+}
+
+// saveManifest writes the manifest, it should be called with s.mu held
+func (s *fileSink) saveManifest(ctx context.Context) error {
+	s.manifest.UpdatedAt = time.Now()
+	return writeJSONFile(ctx, s.store, manifestFile, s.manifest)
+}
+
+func (s *fileSink) putIndex(ctx context.Context, meta indexMeta) error {
+	if err := writeJSONFile(ctx, s.store, mappingFile, meta.Mapping); err != nil {
+		return err
+	}
+	if err := writeJSONFile(ctx, s.store, settingsFile, meta.Settings); err != nil {
+		return err
+	}
+	if meta.Aliases != nil {
+		if err := writeJSONFile(ctx, s.store, aliasesFile, meta.Aliases); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manifest.Mapping = mappingFile
+	s.manifest.Settings = settingsFile
+	if meta.Aliases != nil {
+		s.manifest.Aliases = aliasesFile
+	}
+	return s.saveManifest(ctx)
+}
+
+// dataFilePrefix starts names of data files, leftovers of which are cleaned up by store.cleanup
+const dataFilePrefix = "data"
+
+// windowFileName names data files by window bounds in UTC, so that names sort in time order
+func windowFileName(w window, f format, c codec) string {
+	if w.unbounded() {
+		return dataFilePrefix + f.ext() + c.ext()
+	}
+	const layout = "20060102T150405.000Z"
+	return fmt.Sprintf("%s-%s-%s%s%s", dataFilePrefix, w.Start.UTC().Format(layout), w.End.UTC().Format(layout), f.ext(), c.ext())
+}
+
+// format returns format of data files, manifests written before formats were added have none
+func (m *Manifest) format() format {
+	if m.Format == "" {
+		return formatNDJSON
+	}
+	return format(m.Format)
+}
+
+func (s *fileSink) openWindow(ctx context.Context, w window) (windowWriter, error) {
+	c := codec(s.manifest.Codec)
+	name := windowFileName(w, s.manifest.format(), c)
+	file, err := s.store.create(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	compressor, err := c.writer(file)
+	if err != nil {
+		file.abort()
+		return nil, err
+	}
+	buf := bufio.NewWriter(compressor)
+	encoder, err := s.layout.encoder(buf)
+	if err != nil {
+		compressor.Close()
+		file.abort()
+		return nil, err
+	}
+	return &fileWindow{
+		sink:       s,
+		window:     w,
+		name:       name,
+		file:       file,
+		compressor: compressor,
+		w:          buf,
+		encoder:    encoder,
+	}, nil
+}
+
+// pagesDurable is false as a window file is not listed until the whole window has been written
+func (s *fileSink) pagesDurable() bool {
+	return false
+}
+
+// committed looks the window file up in the manifest, which only lists files of last run if resumed
+func (s *fileSink) committed(w window) (int64, bool) {
+	name := windowFileName(w, s.manifest.format(), codec(s.manifest.Codec))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.manifest.Files {
+		if f.Name == name {
+			return f.Docs, true
+		}
+	}
+	return 0, false
+}
+
+func (s *fileSink) flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveManifest(ctx)
+}
+
+// commitFile lists the file in the manifest, replacing the entry of the same name left by an earlier run
+func (s *fileSink) commitFile(ctx context.Context, file ManifestFile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := s.manifest.Files[:0]
+	for _, f := range s.manifest.Files {
+		if f.Name != file.Name {
+			files = append(files, f)
+		}
+	}
+	files = append(files, file)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	s.manifest.Files = files
+	return s.saveManifest(ctx)
+}
+
+// fileWindow writes docs of one window into a file of store
+type fileWindow struct {
+	sink   *fileSink
+	window window
+	name   string
+	mu     sync.Mutex
+	file   storeWriter
+	// compressor compresses data written to file, w buffers writes to compressor
+	compressor io.WriteCloser
+	w          *bufio.Writer
+	encoder    docEncoder
+	docs       int64
+}
+
+func (f *fileWindow) write(ctx context.Context, hits []*elastic.SearchHit) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.encoder.encode(hits); err != nil {
+		return errors.Wrap(err, "write data file error")
+	}
+	f.docs += int64(len(hits))
+	return nil
+}
+
+func (f *fileWindow) commit(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.encoder.flush(); err != nil {
+		f.compressor.Close()
+		f.file.abort()
+		return errors.Wrap(err, "write data file error")
+	}
+	if err := f.w.Flush(); err != nil {
+		f.compressor.Close()
+		f.file.abort()
+		return errors.Wrap(err, "write data file error")
+	}
+	if err := f.compressor.Close(); err != nil {
+		f.file.abort()
+		return errors.Wrap(err, "write data file error")
+	}
+	if err := f.file.commit(ctx); err != nil {
+		return err
+	}
+	file := ManifestFile{
+		Name: f.name,
+		Docs: f.docs,
+	}
+	if !f.window.unbounded() {
+		start, end := f.window.Start, f.window.End
+		file.Start = &start
+		file.End = &end
+	}
+	return f.sink.commitFile(ctx, file)
+}
+
+// abort discards the file, the window is dumped again from its start on resume
+func (f *fileWindow) abort() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.compressor.Close()
+	f.file.abort()
+}
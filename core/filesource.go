@@ -0,0 +1,342 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Jeffail/gabs/v2"
+	"github.com/araddon/dateparse"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileSource reads a directory written by fileSink, or docs of a single NDJSON file whose lines are
+// either Doc envelopes or bare sources, or in _bulk API format if it has .bulk extension, in a local directory or S3,
+// or docs piped to stdin in _bulk API format.
+// Data files are decompressed by the codec recorded in the manifest, a single file by its .gz or .zst suffix.
+// Includes, excludes and the date range of Conf are applied to each doc, as there is no index to query.
+type fileSource struct {
+	d     *Dumper
+	store store
+	// single is name of the single NDJSON file in store, empty if store holds a dump
+	single string
+	// bulk is true if lines are in _bulk API format, i.e. action lines followed by source lines
+	bulk      bool
+	manifest  *Manifest
+	dateField string
+	filter    *sourceFilter
+}
+
+// newFileSource reads the manifest of st unless single names a single NDJSON file
+func newFileSource(ctx context.Context, st store, single string) (*fileSource, error) {
+	s := &fileSource{
+		store:  st,
+		single: single,
+	}
+	if single == "" {
+		var err error
+		if s.manifest, err = readManifest(ctx, st); err != nil {
+			return nil, err
+		}
+		s.dateField = s.manifest.DateField
+	}
+	f := formatOf(single)
+	if s.manifest != nil {
+		f = s.manifest.format()
+	}
+	if f == formatCSV || f == formatParquet {
+		return nil, &ParseError{Field: "input", Value: st.location(single), Err: errors.Errorf("%s files cannot be imported", f)}
+	}
+	s.bulk = f == formatBulk
+	return s, nil
+}
+
+// index returns name and type of the dumped index, name of a single file without extension is used as index name
+func (s *fileSource) index() (string, string) {
+	if s.manifest != nil {
+		return s.manifest.Index, s.manifest.Type
+	}
+	if s.single == stdio {
+		// index names are known only from action lines
+		return "", "_doc"
+	}
+	name := strings.TrimSuffix(s.single, codecOf(s.single).ext())
+	return strings.TrimSuffix(name, path.Ext(name)), "_doc"
+}
+
+func (s *fileSource) meta(ctx context.Context) (indexMeta, error) {
+	meta := indexMeta{}
+	meta.Index, meta.Type = s.index()
+	if s.manifest == nil {
+		return meta, nil
+	}
+	if s.manifest.Mapping != "" {
+		if err := readJSONFile(ctx, s.store, s.manifest.Mapping, &meta.Mapping); err != nil {
+			return meta, err
+		}
+	}
+	if s.manifest.Settings != "" {
+		if err := readJSONFile(ctx, s.store, s.manifest.Settings, &meta.Settings); err != nil {
+			return meta, err
+		}
+	}
+	if s.manifest.Aliases != "" && s.d.Conf.Aliases {
+		if err := readJSONFile(ctx, s.store, s.manifest.Aliases, &meta.Aliases); err != nil {
+			return meta, err
+		}
+	}
+	return meta, nil
+}
+
+// plan returns a window for each data file whose bounds overlap the date range
+func (s *fileSource) plan(ctx context.Context, last *Checkpoint) ([]window, int64, error) {
+	d := s.d
+	if (d.StartTime != nil || d.EndTime != nil) && s.dateField == "" {
+		return nil, 0, errors.New("start and end dates require date field")
+	}
+	if s.manifest == nil {
+		if last != nil && last.Completed {
+			return nil, 0, nil
+		}
+		return []window{{}}, -1, nil
+	}
+	var boundary *time.Time
+	if last != nil {
+		boundary = last.Boundary()
+	}
+	var (
+		windows []window
+		total   int64
+	)
+	for _, file := range s.manifest.Files {
+		if file.Start == nil || file.End == nil {
+			if last == nil || !last.Completed {
+				windows = append(windows, window{})
+				total += file.Docs
+			}
+			continue
+		}
+		w := window{Start: file.Start.In(time.Local), End: file.End.In(time.Local)}
+		if d.StartTime != nil && !w.End.After(*d.StartTime) || d.EndTime != nil && !w.Start.Before(*d.EndTime) {
+			continue
+		}
+		if boundary != nil {
+			if !d.Conf.Descending && !w.End.After(*boundary) || d.Conf.Descending && !w.Start.Before(*boundary) {
+				continue
+			}
+		}
+		windows = append(windows, w)
+		total += file.Docs
+	}
+	if d.Conf.Descending {
+		for i, j := 0, len(windows)-1; i < j; i, j = i+1, j-1 {
+			windows[i], windows[j] = windows[j], windows[i]
+		}
+	}
+	return windows, total, nil
+}
+
+// fileName returns name of the data file holding docs of window w
+func (s *fileSource) fileName(w window) (string, error) {
+	if s.manifest == nil {
+		return s.single, nil
+	}
+	for _, file := range s.manifest.Files {
+		if w.unbounded() && file.Start == nil ||
+			file.Start != nil && file.End != nil && file.Start.Equal(w.Start) && file.End.Equal(w.End) {
+			return file.Name, nil
+		}
+	}
+	return "", errors.Errorf("no data file of window [%s, %s) in manifest", w.Start, w.End)
+}
+
+// count sums docs of data files listed in the manifest, docs of a single file are unknown until it has been read
+func (s *fileSource) count(ctx context.Context) (int64, error) {
+	if s.manifest == nil {
+		return -1, nil
+	}
+	var total int64
+	for _, file := range s.manifest.Files {
+		total += file.Docs
+	}
+	return total, nil
+}
+
+// read decodes docs of the data file of window w, a file is read by one goroutine only
+func (s *fileSource) read(ctx context.Context, w window, slice int, fn func(hits []*elastic.SearchHit) error) error {
+	if slice > 0 {
+		return nil
+	}
+	name, err := s.fileName(w)
+	if err != nil {
+		return err
+	}
+	file, err := s.store.open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	c := codecOf(name)
+	if s.manifest != nil {
+		c = codec(s.manifest.Codec)
+	}
+	reader, err := c.reader(file)
+	if err != nil {
+		return &ParseError{Field: "data file", Value: s.store.location(name), Err: err}
+	}
+	defer reader.Close()
+	batchSize := s.d.Conf.ScrollSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	var batch []*elastic.SearchHit
+	for {
+		doc, err := s.next(decoder)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return &ParseError{Field: "data file", Value: s.store.location(name), Err: err}
+		}
+		if doc == nil {
+			continue
+		}
+		hit, ok, err := s.hit(*doc)
+		if err != nil {
+			return &ParseError{Field: "data file", Value: s.store.location(name), Err: err}
+		}
+		if !ok {
+			continue
+		}
+		batch = append(batch, hit)
+		if len(batch) < batchSize {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = fn(batch); err != nil {
+			return err
+		}
+		batch = nil
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return fn(batch)
+}
+
+// next decodes the next doc of data file, it is nil if the line has no doc to copy
+func (s *fileSource) next(decoder *json.Decoder) (*Doc, error) {
+	if s.bulk {
+		return readBulk(decoder)
+	}
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	var doc Doc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Source == nil {
+		// a bare source without envelope
+		doc = Doc{Source: raw}
+	}
+	return &doc, nil
+}
+
+// hit converts doc into a search hit, it returns false if the doc is filtered out
+func (s *fileSource) hit(doc Doc) (*elastic.SearchHit, bool, error) {
+	ranged := s.d.StartTime != nil || s.d.EndTime != nil
+	if ranged || !s.filter.empty() {
+		var source map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(doc.Source))
+		decoder.UseNumber()
+		if err := decoder.Decode(&source); err != nil {
+			return nil, false, err
+		}
+		if ranged {
+			value := gabs.Wrap(source).Path(s.dateField).Data()
+			if value == nil {
+				// a range query does not match docs without the field either
+				return nil, false, nil
+			}
+			date, ok := s.date(value)
+			if !ok {
+				return nil, false, &ParseError{Field: "date field " + s.dateField + " of doc " + doc.Id, Value: fmt.Sprint(value), Err: errors.New("unknown date format")}
+			}
+			if s.d.StartTime != nil && date.Before(*s.d.StartTime) || s.d.EndTime != nil && !date.Before(*s.d.EndTime) {
+				return nil, false, nil
+			}
+		}
+		if !s.filter.empty() {
+			data, err := json.Marshal(s.filter.filter(source))
+			if err != nil {
+				return nil, false, err
+			}
+			doc.Source = data
+		}
+	}
+	return &elastic.SearchHit{
+		Index:   doc.Index,
+		Type:    doc.Type,
+		Id:      doc.Id,
+		Routing: doc.Routing,
+		Source:  doc.Source,
+	}, true, nil
+}
+
+// date parses value of the date field of a doc. Epoch numbers are taken as seconds or milliseconds
+// by Conf.DateUnit, or guessed by magnitude the same way as numeric date fields of indices.
+// Dates without offset are taken in Zone.
+func (s *fileSource) date(value interface{}) (time.Time, bool) {
+	return parseDate(value, s.d.Conf.DateUnit, s.d.Zone)
+}
+
+// parseDate parses a date value of source docs, epoch numbers are taken as seconds if unit is "s",
+// milliseconds if unit is "ms", or guessed by magnitude if unit is empty. Date strings are parsed by dateparse,
+// those without offset in zone.
+func parseDate(value interface{}, unit string, zone *time.Location) (time.Time, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return epochTime(f, unit), true
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return epochTime(f, unit), true
+		}
+		if zone == nil {
+			zone = time.UTC
+		}
+		if t, err := dateparse.ParseIn(v, zone); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func epochTime(value float64, unit string) time.Time {
+	d := time.Millisecond
+	switch unit {
+	case "s":
+		d = time.Second
+	case "":
+		if math.Abs(value) < 1e11 {
+			d = time.Second
+		}
+	}
+	return time.Unix(0, int64(math.Round(value*float64(d)))).In(time.Local)
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	for value, expected := range map[string]time.Time{
+		"2021-01-01T10:00:00":           time.Date(2021, 1, 1, 10, 0, 0, 0, shanghai),
+		"2021-01-01T10:00:00.123":       time.Date(2021, 1, 1, 10, 0, 0, 123e6, shanghai),
+		"2021-01-01 10:00:00":           time.Date(2021, 1, 1, 10, 0, 0, 0, shanghai),
+		"2021-01-01":                    time.Date(2021, 1, 1, 0, 0, 0, 0, shanghai),
+		"2021-01-01T10:00:00Z":          time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC),
+		"2021-01-01T10:00:00.123+02:00": time.Date(2021, 1, 1, 8, 0, 0, 123e6, time.UTC),
+	} {
+		date, ok := parseDate(value, "", shanghai)
+		assert.True(t, ok, value)
+		assert.True(t, expected.Equal(date), "%s parsed as %s", value, date)
+	}
+	date, ok := parseDate(json.Number("1609495200"), "", time.UTC)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1609495200), date.Unix())
+	date, ok = parseDate("1609495200123", "ms", time.UTC)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1609495200123), date.UnixNano()/1e6)
+	_, ok = parseDate("not a date", "", time.UTC)
+	assert.False(t, ok)
+}
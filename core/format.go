@@ -0,0 +1,214 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// format is how docs are laid out in data files and streams
+type format string
+
+const (
+	// formatNDJSON writes a Doc per line
+	formatNDJSON format = "ndjson"
+	// formatBulk writes the body of _bulk API requests, which can be replayed by curl --data-binary or Logstash
+	formatBulk format = "bulk"
+	// formatCSV writes a row per doc with nested fields flattened into dotted columns, it cannot be imported
+	formatCSV format = "csv"
+	// formatParquet writes a Parquet file per window with schema derived from the mapping, it cannot be imported
+	formatParquet format = "parquet"
+)
+
+// parseFormat returns the format named s, def is returned if s is empty
+func parseFormat(s string, def format) (format, error) {
+	switch f := format(s); f {
+	case "":
+		return def, nil
+	case formatNDJSON, formatBulk, formatCSV, formatParquet:
+		return f, nil
+	}
+	return "", &ParseError{Field: "format", Value: s, Err: errors.New("format should be ndjson, bulk, csv or parquet")}
+}
+
+// formatOf chooses format of a data file by its extension, ignoring the suffix of its codec
+func formatOf(name string) format {
+	switch path.Ext(strings.TrimSuffix(name, codecOf(name).ext())) {
+	case formatBulk.ext():
+		return formatBulk
+	case formatCSV.ext():
+		return formatCSV
+	case formatParquet.ext():
+		return formatParquet
+	}
+	return formatNDJSON
+}
+
+// ext returns extension of data files of the format
+func (f format) ext() string {
+	switch f {
+	case formatBulk:
+		return ".bulk"
+	case formatCSV:
+		return ".csv"
+	case formatParquet:
+		return ".parquet"
+	}
+	return ".ndjson"
+}
+
+// layout is the format of docs written by a sink along with options of CSV and Parquet
+type layout struct {
+	format format
+	// columns are CSV columns, they are resolved by Dumper before any doc is written
+	columns []string
+	// joiner joins values of arrays in a CSV cell, or in a string column of Parquet
+	joiner string
+	// schema is the Parquet schema, it is resolved by Dumper before any doc is written
+	schema *parquetSchema
+	// compression compresses pages of Parquet files, which are never compressed as a whole
+	compression codec
+}
+
+// resolve completes the layout from source mapping before any doc is written
+func (l *layout) resolve(ctx context.Context, d *Dumper) error {
+	var err error
+	switch {
+	case l.format == formatCSV && l.columns == nil:
+		l.columns, err = d.csvColumns(ctx)
+	case l.format == formatParquet && l.schema == nil:
+		l.schema, err = d.parquetSchema(ctx)
+	}
+	return err
+}
+
+// docEncoder writes docs to a data file or stream
+type docEncoder interface {
+	encode(hits []*elastic.SearchHit) error
+	// flush writes what is buffered by the encoder, it is called before the file or stream is flushed
+	flush() error
+}
+
+// encoder returns an encoder writing docs to w
+func (l *layout) encoder(w io.Writer) (docEncoder, error) {
+	switch l.format {
+	case formatBulk:
+		return bulkEncoder{json.NewEncoder(w)}, nil
+	case formatCSV:
+		return newCSVEncoder(w, l.columns, l.joiner), nil
+	case formatParquet:
+		encoder, err := newParquetEncoder(w, l)
+		if err != nil {
+			return nil, err
+		}
+		return encoder, nil
+	}
+	return ndjsonEncoder{json.NewEncoder(w)}, nil
+}
+
+type ndjsonEncoder struct {
+	*json.Encoder
+}
+
+func (e ndjsonEncoder) encode(hits []*elastic.SearchHit) error {
+	for _, hit := range hits {
+		doc := Doc{
+			Id:      hit.Id,
+			Index:   hit.Index,
+			Type:    hit.Type,
+			Routing: hit.Routing,
+			Source:  hit.Source,
+		}
+		if err := e.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e ndjsonEncoder) flush() error {
+	return nil
+}
+
+// bulkAction is metadata of the action line of a doc in _bulk API format
+type bulkAction struct {
+	Index   string `json:"_index,omitempty"`
+	Type    string `json:"_type,omitempty"`
+	Id      string `json:"_id,omitempty"`
+	Routing string `json:"routing,omitempty"`
+	// LegacyRouting is routing of action lines written for elasticsearch 6 and earlier, it is only read
+	LegacyRouting string `json:"_routing,omitempty"`
+}
+
+// bulkEncoder writes an index action line carrying _index, _id and routing of the source doc followed by the
+// source line for each doc. _type is omitted if it is _doc, so that the body can be sent to any version.
+type bulkEncoder struct {
+	*json.Encoder
+}
+
+func (e bulkEncoder) encode(hits []*elastic.SearchHit) error {
+	for _, hit := range hits {
+		action := bulkAction{
+			Index:   hit.Index,
+			Id:      hit.Id,
+			Routing: hit.Routing,
+		}
+		if hit.Type != "_doc" {
+			action.Type = hit.Type
+		}
+		if err := e.Encode(map[string]bulkAction{"index": action}); err != nil {
+			return err
+		}
+		if err := e.Encode(hit.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e bulkEncoder) flush() error {
+	return nil
+}
+
+// readBulk decodes the next doc of _bulk API format. It returns nil for actions without a doc to copy,
+// i.e. delete actions which have no source line and update actions whose source line is a partial doc.
+func readBulk(decoder *json.Decoder) (*Doc, error) {
+	var action map[string]bulkAction
+	if err := decoder.Decode(&action); err != nil {
+		return nil, err
+	}
+	if len(action) != 1 {
+		return nil, errors.New("action line should have exactly one action")
+	}
+	for op, meta := range action {
+		if op == "delete" {
+			return nil, nil
+		}
+		var source json.RawMessage
+		if err := decoder.Decode(&source); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if op == "update" {
+			return nil, nil
+		}
+		routing := meta.Routing
+		if routing == "" {
+			routing = meta.LegacyRouting
+		}
+		return &Doc{
+			Id:      meta.Id,
+			Index:   meta.Index,
+			Type:    meta.Type,
+			Routing: routing,
+			Source:  source,
+		}, nil
+	}
+	return nil, nil
+}
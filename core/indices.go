@@ -0,0 +1,142 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexPlaceholder in Config.Output is replaced by name of each source index dumped by DumpIndices
+const IndexPlaceholder = "{index}"
+
+// IndexReport tells how one of the indices dumped by DumpIndices went
+type IndexReport struct {
+	// Index is name of source index, Output is the output url it was dumped to
+	Index   string
+	Output  string
+	Summary Summary
+	// Err is why dumping the index failed, nil if it succeeded
+	Err error
+}
+
+// IsIndexPattern reports whether input is an elasticsearch url naming several indices by wildcards or a comma list,
+// such as http://localhost:9200/logs-2023.*
+func IsIndexPattern(input string) bool {
+	u, err := url.Parse(input)
+	if err != nil || input == stdio || isStoreScheme(u) {
+		return false
+	}
+	index, _ := indexAndType(u)
+	return strings.ContainsAny(index, "*,")
+}
+
+// DumpIndices dumps each open index matched by index patterns of conf.Input in name order, the same way as Dumper.Dump
+// dumps a single index. IndexPlaceholder in conf.Output is replaced by name of source index, e.g.
+// http://localhost:9200/{index}-v2, without it all indices are dumped into the same target index.
+// A failed index does not stop the others, fn is called before each index is dumped.
+func DumpIndices(ctx context.Context, conf Config, fn func(i, total int, report IndexReport)) ([]IndexReport, error) {
+	inputUrl, err := url.Parse(conf.Input)
+	if err != nil {
+		return nil, &ParseError{Field: "input", Value: redactURL(conf.Input), Err: err}
+	}
+	if conf.SwapAlias != "" {
+		return nil, &ParseError{Field: "swap alias", Value: conf.SwapAlias, Err: errors.New("alias can only be swapped after dumping a single index")}
+	}
+	outputUrl, err := url.Parse(conf.Output)
+	if err != nil {
+		return nil, &ParseError{Field: "output", Value: redactURL(conf.Output), Err: err}
+	}
+	if isStoreScheme(outputUrl) && !strings.Contains(conf.Output, IndexPlaceholder) {
+		// a dump directory holds a single index
+		return nil, &ParseError{Field: "output", Value: redactURL(conf.Output), Err: errors.Errorf("output should contain %s to dump each index into its own directory", IndexPlaceholder)}
+	}
+	indices, err := resolveIndices(ctx, inputUrl, conf.Input)
+	if err != nil {
+		return nil, err
+	}
+	if len(indices) == 0 {
+		return nil, errors.Errorf("no open index matches %s", redactURL(conf.Input))
+	}
+	path := strings.Split(strings.Trim(inputUrl.Path, "/"), "/")
+	var (
+		reports []IndexReport
+		failed  int
+	)
+	for i, index := range indices {
+		if ctx.Err() != nil {
+			break
+		}
+		indexConf := conf
+		u := *inputUrl
+		u.Path = "/" + index
+		if len(path) > 1 {
+			// type given in input url
+			u.Path += "/" + path[1]
+		}
+		indexConf.Input = u.String()
+		indexConf.Output = strings.ReplaceAll(conf.Output, IndexPlaceholder, index)
+		report := IndexReport{
+			Index:  index,
+			Output: redactURL(indexConf.Output),
+		}
+		if fn != nil {
+			fn(i, len(indices), report)
+		}
+		var dumper *Dumper
+		if dumper, report.Err = NewDumper(indexConf); report.Err == nil {
+			report.Err = dumper.Dump(ctx)
+			report.Summary = dumper.Summary()
+		}
+		if report.Err != nil {
+			failed++
+		}
+		reports = append(reports, report)
+	}
+	if ctx.Err() != nil {
+		return reports, ctx.Err()
+	}
+	if failed > 0 {
+		return reports, errors.Errorf("%d of %d indices failed", failed, len(indices))
+	}
+	return reports, nil
+}
+
+// resolveIndices returns names of open indices matched by the comma separated index patterns of u by _cat/indices,
+// patterns starting with - exclude indices matched by earlier patterns. Hidden indices, i.e. names starting with a dot,
+// are left out unless a pattern starts with a dot.
+func resolveIndices(ctx context.Context, u *url.URL, rawURL string) ([]string, error) {
+	client, err := newClient(u)
+	if err != nil {
+		return nil, err
+	}
+	index, _ := indexAndType(u)
+	catCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	rows, err := client.CatIndices().Index(index).Columns("index", "status").Do(catCtx)
+	if err != nil {
+		return nil, requestError(err, rawURL, "resolve indices of "+index+" error")
+	}
+	hidden := strings.HasPrefix(index, ".") || strings.Contains(index, ",.")
+	var indices []string
+	for _, row := range rows {
+		// closed indices cannot be read
+		if row.Status == "open" && (hidden || !strings.HasPrefix(row.Index, ".")) {
+			indices = append(indices, row.Index)
+		}
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// String formats the report as one line, such as "logs-2023.01.01 -> http://localhost:9200/logs-2023.01.01-v2: dumped 10 docs in 1s"
+func (r IndexReport) String() string {
+	status := r.Summary.String()
+	if r.Err != nil {
+		status = "failed: " + r.Err.Error()
+	}
+	return fmt.Sprintf("%s -> %s: %s", r.Index, r.Output, status)
+}
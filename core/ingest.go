@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pipelineSettings are index settings naming ingest pipelines which docs indexed into the index go through
+var pipelineSettings = []string{"default_pipeline", "final_pipeline"}
+
+// copyPipelines copies ingest pipelines named by settings from source cluster to target cluster,
+// along with pipelines and stored scripts they refer to, so that docs indexed into target index after the
+// migration are processed the same way. Pipelines and scripts of the same names in target cluster are replaced.
+func (d *Dumper) copyPipelines(ctx context.Context, settings map[string]interface{}) error {
+	index, _ := nestSettings(settings)["index"].(map[string]interface{})
+	var names []string
+	for _, setting := range pipelineSettings {
+		// _none disables the default pipeline set by a template
+		if name, _ := index[setting].(string); name != "" && name != "_none" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	c := &MetaCopier{
+		Conf:         MetaConfig{Input: d.Conf.Input, Output: d.Conf.Output},
+		SourceClient: d.SourceClient,
+		TargetClient: d.TargetClient,
+	}
+	pipelineKind, err := metaKindOf("ingest_pipeline")
+	if err != nil {
+		return err
+	}
+	scriptKind, err := metaKindOf("stored_script")
+	if err != nil {
+		return err
+	}
+	pipelines, err := c.objects(ctx, c.SourceClient, c.Conf.Input, pipelineKind)
+	if err != nil {
+		return err
+	}
+	scripts, err := c.objects(ctx, c.SourceClient, c.Conf.Input, scriptKind)
+	if err != nil {
+		return err
+	}
+
+	var pipelineChanges, scriptChanges []MetaChange
+	seen := make(map[string]bool)
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		pipeline, ok := pipelines[name]
+		if !ok {
+			return &MappingError{Index: d.TargetIndex, Err: errors.Errorf("ingest pipeline %s is not found in source cluster", name)}
+		}
+		pipelineChanges = append(pipelineChanges, MetaChange{Kind: "ingest_pipeline", Name: name, Action: MetaCreate, body: pipeline})
+		refPipelines, refScripts := pipelineRefs(pipeline)
+		names = append(names, refPipelines...)
+		for _, id := range refScripts {
+			if seen["script:"+id] {
+				continue
+			}
+			seen["script:"+id] = true
+			script, ok := scripts[id]
+			if !ok {
+				return &MappingError{Index: d.TargetIndex, Err: errors.Errorf("stored script %s run by ingest pipeline %s is not found in source cluster", id, name)}
+			}
+			scriptChanges = append(scriptChanges, MetaChange{Kind: "stored_script", Name: id, Action: MetaCreate, body: script})
+		}
+	}
+	// scripts are stored before pipelines running them, and pipelines referred to before those referring to them
+	changes := scriptChanges
+	for i := len(pipelineChanges) - 1; i >= 0; i-- {
+		changes = append(changes, pipelineChanges[i])
+	}
+	return c.Apply(ctx, changes)
+}
+
+// pipelineRefs returns names of pipelines run by pipeline processors and ids of stored scripts run by script
+// processors of pipeline, including processors nested in foreach and on_failure
+func pipelineRefs(pipeline interface{}) (pipelines, scripts []string) {
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if processor, ok := v["pipeline"].(map[string]interface{}); ok {
+				// a templated name is only known at ingest time
+				if name, _ := processor["name"].(string); name != "" && !strings.Contains(name, "{{") {
+					pipelines = append(pipelines, name)
+				}
+			}
+			if processor, ok := v["script"].(map[string]interface{}); ok {
+				if id, _ := processor["id"].(string); id != "" {
+					scripts = append(scripts, id)
+				}
+			}
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				walk(v[key])
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(pipeline)
+	return
+}
+
+func metaKindOf(name string) (metaKind, error) {
+	for _, kind := range metaKinds {
+		if kind.name == name {
+			return kind, nil
+		}
+	}
+	return metaKind{}, errors.Errorf("unknown kind %q", name)
+}
+
+// splitPipelineSettings deletes pipeline settings from nested settings and returns them by dotted keys, nil if none.
+// Docs copied from source index have been through its pipelines already and final_pipeline cannot be bypassed by
+// bulk requests, so target index is created without them and they are put after data has been copied.
+func splitPipelineSettings(settings map[string]interface{}) map[string]interface{} {
+	index, _ := settings["index"].(map[string]interface{})
+	var held map[string]interface{}
+	for _, setting := range pipelineSettings {
+		if value, ok := index[setting]; ok {
+			if held == nil {
+				held = make(map[string]interface{})
+			}
+			held["index."+setting] = value
+			deleteSetting(settings, "index."+setting)
+		}
+	}
+	return held
+}
+
+// putPipelineSettings puts pipeline settings held back by splitPipelineSettings onto target index
+func (d *Dumper) putPipelineSettings(ctx context.Context) error {
+	putCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := d.TargetClient.IndexPutSettings(d.TargetIndex).BodyJson(d.pipelineSettings).Do(putCtx); err != nil {
+		if err = requestError(err, d.Conf.Output, "put pipeline settings error"); isConnectionError(err) {
+			return err
+		}
+		return &MappingError{Index: d.TargetIndex, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetaConfig configures copying cluster-level objects between clusters
+type MetaConfig struct {
+	// Input and Output are connection urls of source and target clusters, paths are ignored
+	Input  string
+	Output string
+	// Kinds are comma separated kinds of objects to copy, "stored_script", "ingest_pipeline", "ilm_policy",
+	// "component_template", "index_template" and "template" for legacy templates. Empty means all.
+	Kinds string
+	// Patterns are comma separated name patterns of objects to copy, * matches any characters. Empty means all.
+	Patterns string
+}
+
+// metaKind is a kind of cluster-level object, listed by GET on path and written by PUT on path followed by name
+type metaKind struct {
+	name string
+	path string
+	// listPath and listParams are where objects are listed if they cannot be listed by GET on path
+	listPath   string
+	listParams url.Values
+	// objects returns writable bodies of objects by name from the response of GET on path
+	objects func(data json.RawMessage) (map[string]interface{}, error)
+}
+
+// metaKinds are in the order they are written, so that objects exist before others referring to them,
+// e.g. pipelines run stored scripts and index templates are composed of component templates
+var metaKinds = []metaKind{
+	{
+		name: "stored_script",
+		path: "/_scripts",
+		// there is no API listing stored scripts but the cluster state
+		listPath:   "/_cluster/state/metadata",
+		listParams: url.Values{"filter_path": []string{"metadata.stored_scripts"}},
+		objects: func(data json.RawMessage) (map[string]interface{}, error) {
+			var resp struct {
+				Metadata struct {
+					StoredScripts map[string]interface{} `json:"stored_scripts"`
+				} `json:"metadata"`
+			}
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return nil, err
+			}
+			objects := make(map[string]interface{}, len(resp.Metadata.StoredScripts))
+			for id, script := range resp.Metadata.StoredScripts {
+				objects[id] = map[string]interface{}{"script": script}
+			}
+			return objects, nil
+		},
+	},
+	{
+		name: "ingest_pipeline",
+		path: "/_ingest/pipeline",
+		objects: func(data json.RawMessage) (map[string]interface{}, error) {
+			var objects map[string]interface{}
+			if err := json.Unmarshal(data, &objects); err != nil {
+				return nil, err
+			}
+			return objects, nil
+		},
+	},
+	{
+		name: "ilm_policy",
+		path: "/_ilm/policy",
+		objects: func(data json.RawMessage) (map[string]interface{}, error) {
+			var resp map[string]struct {
+				Policy interface{} `json:"policy"`
+			}
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return nil, err
+			}
+			// version, modified_date and in_use_by are assigned by the cluster
+			objects := make(map[string]interface{}, len(resp))
+			for name, item := range resp {
+				objects[name] = map[string]interface{}{"policy": item.Policy}
+			}
+			return objects, nil
+		},
+	},
+	{
+		name: "component_template",
+		path: "/_component_template",
+		objects: func(data json.RawMessage) (map[string]interface{}, error) {
+			var resp struct {
+				ComponentTemplates []struct {
+					Name              string      `json:"name"`
+					ComponentTemplate interface{} `json:"component_template"`
+				} `json:"component_templates"`
+			}
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return nil, err
+			}
+			objects := make(map[string]interface{}, len(resp.ComponentTemplates))
+			for _, item := range resp.ComponentTemplates {
+				objects[item.Name] = item.ComponentTemplate
+			}
+			return objects, nil
+		},
+	},
+	{
+		name: "index_template",
+		path: "/_index_template",
+		objects: func(data json.RawMessage) (map[string]interface{}, error) {
+			var resp struct {
+				IndexTemplates []struct {
+					Name          string      `json:"name"`
+					IndexTemplate interface{} `json:"index_template"`
+				} `json:"index_templates"`
+			}
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return nil, err
+			}
+			objects := make(map[string]interface{}, len(resp.IndexTemplates))
+			for _, item := range resp.IndexTemplates {
+				objects[item.Name] = item.IndexTemplate
+			}
+			return objects, nil
+		},
+	},
+	{
+		name: "template",
+		path: "/_template",
+		objects: func(data json.RawMessage) (map[string]interface{}, error) {
+			var objects map[string]interface{}
+			if err := json.Unmarshal(data, &objects); err != nil {
+				return nil, err
+			}
+			return objects, nil
+		},
+	},
+}
+
+// what a MetaChange does to target cluster
+const (
+	MetaCreate    = "create"
+	MetaUpdate    = "update"
+	MetaUnchanged = "unchanged"
+)
+
+// MetaChange is a cluster-level object of source cluster to be written to target cluster
+type MetaChange struct {
+	Kind   string
+	Name   string
+	Action string
+	// Diff is a unified diff from the object of target cluster to that of source cluster, empty if unchanged
+	Diff string
+	body interface{}
+}
+
+// MetaCopier copies stored scripts, ingest pipelines, ILM policies, index templates and component templates
+// from source cluster to target cluster
+type MetaCopier struct {
+	Conf         MetaConfig
+	SourceClient *elastic.Client
+	TargetClient *elastic.Client
+	kinds        []metaKind
+	patterns     []*regexp.Regexp
+}
+
+func NewMetaCopier(conf MetaConfig) (*MetaCopier, error) {
+	inputUrl, err := url.Parse(conf.Input)
+	if err != nil {
+		return nil, &ParseError{Field: "input", Value: redactURL(conf.Input), Err: err}
+	}
+	outputUrl, err := url.Parse(conf.Output)
+	if err != nil {
+		return nil, &ParseError{Field: "output", Value: redactURL(conf.Output), Err: err}
+	}
+	kinds := metaKinds
+	if strings.TrimSpace(conf.Kinds) != "" {
+		wanted := make(map[string]bool)
+		for _, kind := range strings.Split(conf.Kinds, ",") {
+			wanted[strings.TrimSpace(kind)] = true
+		}
+		kinds = nil
+		for _, kind := range metaKinds {
+			if wanted[kind.name] {
+				kinds = append(kinds, kind)
+				delete(wanted, kind.name)
+			}
+		}
+		for kind := range wanted {
+			return nil, &ParseError{Field: "kinds", Value: conf.Kinds, Err: errors.Errorf("unknown kind %q, kinds should be stored_script, ingest_pipeline, ilm_policy, component_template, index_template or template", kind)}
+		}
+	}
+	patterns := compilePatterns(strings.Split(conf.Patterns, ","))
+	if len(patterns) == 0 {
+		patterns = compilePatterns([]string{"*"})
+	}
+	source, err := newClient(inputUrl)
+	if err != nil {
+		return nil, err
+	}
+	target, err := newClient(outputUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &MetaCopier{
+		Conf:         conf,
+		SourceClient: source,
+		TargetClient: target,
+		kinds:        kinds,
+		patterns:     patterns,
+	}, nil
+}
+
+// Plan compares objects of source cluster matching Conf.Patterns with those of target cluster, changes are
+// in the order they would be applied. Hidden objects, i.e. names starting with a dot, and objects managed by
+// elasticsearch itself are left out, as each cluster has its own.
+func (c *MetaCopier) Plan(ctx context.Context) ([]MetaChange, error) {
+	var changes []MetaChange
+	for _, kind := range c.kinds {
+		sources, err := c.objects(ctx, c.SourceClient, c.Conf.Input, kind)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := c.objects(ctx, c.TargetClient, c.Conf.Output, kind)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(sources))
+		for name, body := range sources {
+			if matchAny(c.patterns, name) && !strings.HasPrefix(name, ".") && !managed(body) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			change := MetaChange{
+				Kind:   kind.name,
+				Name:   name,
+				Action: MetaCreate,
+				body:   sources[name],
+			}
+			from, fromFile := "", "/dev/null"
+			if body, ok := targets[name]; ok {
+				change.Action = MetaUpdate
+				from, fromFile = prettyJSON(body), c.location(c.Conf.Output, kind, name)
+			}
+			to := prettyJSON(change.body)
+			if from == to {
+				change.Action = MetaUnchanged
+			} else {
+				change.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+					A:        difflib.SplitLines(from),
+					B:        difflib.SplitLines(to),
+					FromFile: fromFile,
+					ToFile:   c.location(c.Conf.Input, kind, name),
+					Context:  3,
+				})
+				if err != nil {
+					return nil, errors.Wrap(err, "diff error")
+				}
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// Apply writes created and updated objects of changes to target cluster in order, it stops at the first failure
+func (c *MetaCopier) Apply(ctx context.Context, changes []MetaChange) error {
+	paths := make(map[string]string, len(metaKinds))
+	for _, kind := range metaKinds {
+		paths[kind.name] = kind.path
+	}
+	for _, change := range changes {
+		if change.Action == MetaUnchanged {
+			continue
+		}
+		putCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		_, err := c.TargetClient.PerformRequest(putCtx, elastic.PerformRequestOptions{
+			Method: http.MethodPut,
+			Path:   paths[change.Kind] + "/" + url.PathEscape(change.Name),
+			Body:   change.body,
+		})
+		cancel()
+		if err != nil {
+			return requestError(err, c.Conf.Output, fmt.Sprintf("put %s %s error", change.Kind, change.Name))
+		}
+	}
+	return nil
+}
+
+// objects returns writable bodies of objects of kind by name, none if the cluster does not support kind
+func (c *MetaCopier) objects(ctx context.Context, client *elastic.Client, rawURL string, kind metaKind) (map[string]interface{}, error) {
+	getCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	path := kind.path
+	if kind.listPath != "" {
+		path = kind.listPath
+	}
+	resp, err := client.PerformRequest(getCtx, elastic.PerformRequestOptions{
+		Method: http.MethodGet,
+		Path:   path,
+		Params: kind.listParams,
+	})
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			// e.g. no policy or template yet
+			return nil, nil
+		}
+		return nil, requestError(err, rawURL, fmt.Sprintf("get %s of %s error", kind.name, redactURL(rawURL)))
+	}
+	objects, err := kind.objects(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode %s of %s error", kind.name, redactURL(rawURL))
+	}
+	return objects, nil
+}
+
+func (c *MetaCopier) location(rawURL string, kind metaKind, name string) string {
+	u, err := url.Parse(redactURL(rawURL))
+	if err != nil {
+		return kind.path + "/" + name
+	}
+	return u.Scheme + "://" + u.Host + kind.path + "/" + name
+}
+
+// managed reports whether the object is managed by elasticsearch, which marks it by _meta.managed
+func managed(body interface{}) bool {
+	object, _ := body.(map[string]interface{})
+	if policy, ok := object["policy"].(map[string]interface{}); ok {
+		object = policy
+	}
+	meta, _ := object["_meta"].(map[string]interface{})
+	isManaged, _ := meta["managed"].(bool)
+	return isManaged
+}
+
+// prettyJSON formats body with sorted keys, so that equal objects have equal text
+func prettyJSON(body interface{}) string {
+	data, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		return fmt.Sprint(body)
+	}
+	return string(data) + "\n"
+}
@@ -0,0 +1,445 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parquetKind is the Parquet type which values of a leaf field are converted to
+type parquetKind string
+
+const (
+	parquetString          parquetKind = "string"
+	parquetInt64           parquetKind = "int64"
+	parquetInt32           parquetKind = "int32"
+	parquetDouble          parquetKind = "double"
+	parquetFloat           parquetKind = "float"
+	parquetBool            parquetKind = "boolean"
+	parquetTimestamp       parquetKind = "timestamp"
+	parquetTimestampMicros parquetKind = "timestamp_micros"
+	// parquetJSON is a string column holding values as JSON, for types without Parquet counterpart such as geo_point
+	parquetJSON parquetKind = "json"
+)
+
+// parquetKindOf maps field types of elasticsearch to Parquet types
+func parquetKindOf(esType string) parquetKind {
+	switch esType {
+	case "keyword", "constant_keyword", "wildcard", "text", "match_only_text", "search_as_you_type", "ip", "version":
+		return parquetString
+	case "long", "unsigned_long":
+		return parquetInt64
+	case "integer", "short", "byte":
+		return parquetInt32
+	case "double", "scaled_float":
+		return parquetDouble
+	case "float", "half_float":
+		return parquetFloat
+	case "boolean":
+		return parquetBool
+	case "date":
+		return parquetTimestamp
+	case "date_nanos":
+		return parquetTimestampMicros
+	}
+	return parquetJSON
+}
+
+// parquetSchema is the Parquet schema derived from the mapping of source index
+type parquetSchema struct {
+	fields []*parquetField
+	// json is the schema in the JSON format of parquet-go
+	json string
+}
+
+// parquetField is a column or a group of the schema. Objects are mapped to structs and nested fields to lists of structs.
+type parquetField struct {
+	name string
+	// path is the dotted path of the field in source docs
+	path string
+	// kind is empty for objects and nested fields
+	kind parquetKind
+	// unit is unit of epoch numbers of date fields, "s" or "ms"
+	unit string
+	// zone is time zone of dates without offset
+	zone   *time.Location
+	nested bool
+	fields []*parquetField
+}
+
+// parquetSchema derives the Parquet schema from source mapping, leaf fields are filtered by includes and excludes
+func (d *Dumper) parquetSchema(ctx context.Context) (*parquetSchema, error) {
+	meta, err := d.sourceMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Mapping == nil {
+		return nil, &ParseError{Field: "format", Value: string(formatParquet), Err: errors.New("Parquet schema cannot be derived as source has no mapping")}
+	}
+	fields := parquetFields("", meta.Mapping, compilePatterns(d.Includes), compilePatterns(d.Excludes), d.Zone)
+	if len(fields) == 0 {
+		return nil, &ParseError{Field: "includes", Value: d.Conf.Includes, Err: errors.New("no field of the mapping is left for Parquet schema")}
+	}
+	root := map[string]interface{}{
+		"Tag":    "name=parquet_go_root, repetitiontype=REQUIRED",
+		"Fields": parquetTags(fields),
+	}
+	data, err := json.Marshal(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "call Marshal() error")
+	}
+	return &parquetSchema{
+		fields: fields,
+		json:   string(data),
+	}, nil
+}
+
+// parquetFields returns fields under properties of mapping sorted by name, objects without any field left are dropped.
+// Dates without offset are taken in zone.
+func parquetFields(prefix string, mapping map[string]interface{}, includes, excludes []*regexp.Regexp, zone *time.Location) []*parquetField {
+	properties, _ := mapping["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []*parquetField
+	for _, name := range names {
+		property, _ := properties[name].(map[string]interface{})
+		field := &parquetField{
+			name: name,
+			path: prefix + name,
+		}
+		esType, _ := property["type"].(string)
+		if _, ok := property["properties"]; ok {
+			field.nested = esType == "nested"
+			if field.fields = parquetFields(field.path+".", property, includes, excludes, zone); len(field.fields) > 0 {
+				fields = append(fields, field)
+			}
+			continue
+		}
+		if len(includes) > 0 && !matchField(includes, field.path) || matchField(excludes, field.path) {
+			continue
+		}
+		field.kind = parquetKindOf(esType)
+		field.unit = "ms"
+		field.zone = zone
+		format, _ := property["format"].(string)
+		if strings.Contains(format, "epoch_second") {
+			field.unit = "s"
+		}
+		if (field.kind == parquetTimestamp || field.kind == parquetTimestampMicros) && !parsableDateFormat(format) {
+			// values are kept as they are rather than guessed at
+			field.kind = parquetString
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// parsableDateFormats are date formats of elasticsearch whose values parseDate understands
+var parsableDateFormats = map[string]bool{
+	"strict_date_optional_time":       true,
+	"date_optional_time":              true,
+	"strict_date_optional_time_nanos": true,
+	"strict_date_time":                true,
+	"date_time":                       true,
+	"strict_date_time_no_millis":      true,
+	"date_time_no_millis":             true,
+	"strict_date":                     true,
+	"date":                            true,
+	"epoch_millis":                    true,
+	"epoch_second":                    true,
+	"yyyy-MM-dd":                      true,
+	"yyyy-MM-dd HH:mm":                true,
+	"yyyy-MM-dd HH:mm:ss":             true,
+	"yyyy/MM/dd":                      true,
+}
+
+// parsableDateFormat reports whether all formats of a date field are parsable, no format means the default one
+func parsableDateFormat(format string) bool {
+	if format == "" {
+		return true
+	}
+	for _, f := range strings.Split(format, "||") {
+		if !parsableDateFormats[strings.TrimSpace(f)] {
+			return false
+		}
+	}
+	return true
+}
+
+// parquetTags returns fields in the JSON schema format of parquet-go, all fields are optional
+func parquetTags(fields []*parquetField) []interface{} {
+	tags := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		var tag string
+		switch field.kind {
+		case "":
+			if field.nested {
+				tags = append(tags, map[string]interface{}{
+					"Tag": fmt.Sprintf("name=%s, type=LIST, repetitiontype=OPTIONAL", field.name),
+					"Fields": []interface{}{map[string]interface{}{
+						"Tag":    "name=element, repetitiontype=REQUIRED",
+						"Fields": parquetTags(field.fields),
+					}},
+				})
+				continue
+			}
+			tags = append(tags, map[string]interface{}{
+				"Tag":    fmt.Sprintf("name=%s, repetitiontype=OPTIONAL", field.name),
+				"Fields": parquetTags(field.fields),
+			})
+			continue
+		case parquetInt64:
+			tag = "type=INT64"
+		case parquetInt32:
+			tag = "type=INT32"
+		case parquetDouble:
+			tag = "type=DOUBLE"
+		case parquetFloat:
+			tag = "type=FLOAT"
+		case parquetBool:
+			tag = "type=BOOLEAN"
+		case parquetTimestamp:
+			tag = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+		case parquetTimestampMicros:
+			tag = "type=INT64, convertedtype=TIMESTAMP_MICROS"
+		default:
+			tag = "type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+		tags = append(tags, map[string]interface{}{
+			"Tag": fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", field.name, tag),
+		})
+	}
+	return tags
+}
+
+// convertObject converts fields of object into a record of the schema, fields missing from the schema are dropped
+func convertObject(fields []*parquetField, object map[string]interface{}, joiner string) (map[string]interface{}, error) {
+	record := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		value, ok := object[field.name]
+		if !ok || value == nil {
+			continue
+		}
+		converted, err := field.convert(value, joiner)
+		if err != nil {
+			return nil, err
+		}
+		if converted != nil {
+			record[field.name] = converted
+		}
+	}
+	return record, nil
+}
+
+// convert converts value of the field. Arrays are accepted by single valued columns if they have at most one value,
+// or if the column is a string, whose values are joined by joiner.
+func (f *parquetField) convert(value interface{}, joiner string) (interface{}, error) {
+	if f.nested {
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		list := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("nested field %s holds %v which is not an object", f.path, item)
+			}
+			record, err := convertObject(f.fields, object, joiner)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, record)
+		}
+		return list, nil
+	}
+	if f.kind == parquetJSON {
+		// arrays are kept as a whole
+		return f.convertLeaf(value)
+	}
+	if items, ok := value.([]interface{}); ok {
+		switch {
+		case len(items) == 0:
+			return nil, nil
+		case len(items) == 1:
+			return f.convert(items[0], joiner)
+		case f.kind == parquetString:
+			parts := make([]string, 0, len(items))
+			for _, item := range items {
+				part, err := f.convert(item, joiner)
+				if err != nil {
+					return nil, err
+				}
+				if part != nil {
+					parts = append(parts, part.(string))
+				}
+			}
+			return strings.Join(parts, joiner), nil
+		case f.kind == "":
+			return nil, errors.Errorf("object field %s holds %d objects which cannot be written into a single struct, exclude it or map it as nested", f.path, len(items))
+		}
+		return nil, errors.Errorf("field %s holds %d values which cannot be written into a single %s column, exclude it", f.path, len(items), f.kind)
+	}
+	if f.kind == "" {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("object field %s holds %v which is not an object", f.path, value)
+		}
+		return convertObject(f.fields, object, joiner)
+	}
+	return f.convertLeaf(value)
+}
+
+func (f *parquetField) convertLeaf(value interface{}) (interface{}, error) {
+	switch f.kind {
+	case parquetString:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case parquetInt64, parquetInt32:
+		n, err := strconv.ParseInt(numberString(value), 10, 64)
+		if err != nil {
+			// e.g. 1.0 in a long field
+			var f64 float64
+			if f64, err = strconv.ParseFloat(numberString(value), 64); err != nil {
+				break
+			}
+			n = int64(f64)
+		}
+		if f.kind == parquetInt32 {
+			if n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, errors.Errorf("field %s holds %v which overflows int32", f.path, value)
+			}
+			return int32(n), nil
+		}
+		return n, nil
+	case parquetDouble, parquetFloat:
+		n, err := strconv.ParseFloat(numberString(value), 64)
+		if err != nil {
+			break
+		}
+		return n, nil
+	case parquetBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+	case parquetTimestamp, parquetTimestampMicros:
+		t, ok := parseDate(value, f.unit, f.zone)
+		if !ok {
+			break
+		}
+		if f.kind == parquetTimestampMicros {
+			return t.UnixNano() / 1000, nil
+		}
+		return t.UnixNano() / 1000000, nil
+	}
+	if f.kind == parquetJSON {
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal field %s error", f.path)
+		}
+		return string(data), nil
+	}
+	return nil, errors.Errorf("field %s holds %v which cannot be converted to %s", f.path, value, f.kind)
+}
+
+func numberString(value interface{}) string {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// parquetCompression maps codecs to compression codecs of Parquet pages, pages are compressed by snappy by default
+func parquetCompression(c codec) parquet.CompressionCodec {
+	switch c {
+	case codecGzip:
+		return parquet.CompressionCodec_GZIP
+	case codecZstd:
+		return parquet.CompressionCodec_ZSTD
+	}
+	return parquet.CompressionCodec_SNAPPY
+}
+
+// parquetEncoder writes docs into a Parquet file. Rows are buffered in memory until a row group is filled or
+// the file is flushed, so each window file has its own row groups.
+type parquetEncoder struct {
+	writer *writer.JSONWriter
+	schema *parquetSchema
+	joiner string
+}
+
+func newParquetEncoder(w io.Writer, l *layout) (*parquetEncoder, error) {
+	pw, err := writer.NewJSONWriterFromWriter(l.schema.json, w, 1)
+	if err != nil {
+		return nil, errors.Wrap(err, "create parquet writer error")
+	}
+	pw.CompressionType = parquetCompression(l.compression)
+	return &parquetEncoder{
+		writer: pw,
+		schema: l.schema,
+		joiner: l.joiner,
+	}, nil
+}
+
+func (e *parquetEncoder) encode(hits []*elastic.SearchHit) error {
+	for _, hit := range hits {
+		var source map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(hit.Source))
+		decoder.UseNumber()
+		if err := decoder.Decode(&source); err != nil {
+			return errors.Wrapf(err, "decode source of doc %s error", hit.Id)
+		}
+		record, err := convertObject(e.schema.fields, source, e.joiner)
+		if err != nil {
+			return errors.Wrapf(err, "convert doc %s error", hit.Id)
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return errors.Wrapf(err, "marshal doc %s error", hit.Id)
+		}
+		if err = e.writer.Write(string(data)); err != nil {
+			return errors.Wrapf(err, "write doc %s error", hit.Id)
+		}
+	}
+	return nil
+}
+
+// flush writes the last row group and the footer, nothing can be written after it
+func (e *parquetEncoder) flush() error {
+	if err := e.writer.WriteStop(); err != nil {
+		return errors.Wrap(err, "write parquet footer error")
+	}
+	return nil
+}
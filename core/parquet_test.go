@@ -0,0 +1,43 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParquetField_ConvertDate(t *testing.T) {
+	var mapping map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"properties": {
+		"createAt": {"type": "date"},
+		"pubAt": {"type": "date", "format": "yyyy-MM-dd HH:mm:ss"},
+		"dotted": {"type": "date", "format": "dd.MM.yyyy"}
+	}}`), &mapping))
+	at := time.Date(2021, 1, 1, 10, 0, 0, 123e6, time.UTC)
+	for _, zone := range []*time.Location{time.UTC, time.FixedZone("CST", 8*3600)} {
+		fields := parquetFields("", mapping, nil, nil, zone)
+		require.Len(t, fields, 3)
+		kinds := make(map[string]parquetKind)
+		for _, field := range fields {
+			kinds[field.name] = field.kind
+		}
+		assert.Equal(t, map[string]parquetKind{"createAt": parquetTimestamp, "pubAt": parquetTimestamp, "dotted": parquetString}, kinds)
+
+		// dates without offset are taken in zone, as elasticsearch takes them in UTC unless told otherwise
+		local := at.In(zone)
+		record, err := convertObject(fields, map[string]interface{}{
+			"createAt": local.Format("2006-01-02T15:04:05.000"),
+			"pubAt":    local.Format("2006-01-02 15:04:05"),
+			"dotted":   "01.01.2021",
+		}, ",")
+		require.NoError(t, err)
+		assert.Equal(t, at.UnixNano()/1e6, record["createAt"], zone.String())
+		assert.Equal(t, at.Truncate(time.Second).UnixNano()/1e6, record["pubAt"], zone.String())
+		assert.Equal(t, "01.01.2021", record["dotted"])
+	}
+
+	_, err := convertObject(parquetFields("", mapping, nil, nil, time.UTC), map[string]interface{}{"createAt": "yesterday"}, ",")
+	assert.Error(t, err)
+}
@@ -0,0 +1,263 @@
+package core
+
+import (
+	"context"
+	"github.com/olivere/elastic/v7"
+	"github.com/unionj-cloud/go-doudou/toolkit/stringutils"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// copyWindows dumps windows by Conf.Workers goroutines concurrently. Windows are handed out in order,
+// so with a single worker they are dumped one by one as before.
+func (d *Dumper) copyWindows(ctx context.Context, windows []window, p *progress) error {
+	workers := d.Conf.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	indexes := make(chan int)
+	g.Go(func() error {
+		defer close(indexes)
+		for i := range windows {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	for w := 0; w < workers; w++ {
+		g.Go(func() error {
+			for i := range indexes {
+				out, err := d.sink.openWindow(ctx, windows[i])
+				if err != nil {
+					return err
+				}
+				_, err = d.copyWindow(ctx, windows[i], out, func(hits []*elastic.SearchHit) error {
+					var cursor *time.Time
+					if d.dateField != nil {
+						cursor = d.dateField.cursor(hits[len(hits)-1])
+					}
+					return p.page(i, len(hits), cursor)
+				})
+				if err != nil {
+					out.abort()
+					return err
+				}
+				if err = out.commit(ctx); err != nil {
+					return err
+				}
+				if err = p.window(i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// copyWindow streams docs of window w from source to out.
+// Pages are read and written by different goroutines as soon as they arrive, at most one page per
+// reader is buffered in between, so memory usage is bounded by scroll size rather than by window size.
+// If Conf.Slices is greater than 1, the window is read by that many readers in parallel.
+// onPage is called after each page has been written to out.
+func (d *Dumper) copyWindow(ctx context.Context, w window, out windowWriter, onPage func(hits []*elastic.SearchHit) error) (int64, error) {
+	slices := d.Conf.Slices
+	if slices <= 0 {
+		slices = 1
+	}
+	pages := make(chan []*elastic.SearchHit, slices)
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		defer close(pages)
+		readers, ctx := errgroup.WithContext(ctx)
+		for i := 0; i < slices; i++ {
+			slice := i
+			readers.Go(func() error {
+				return d.source.read(ctx, w, slice, func(hits []*elastic.SearchHit) error {
+					select {
+					case pages <- hits:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				})
+			})
+		}
+		return readers.Wait()
+	})
+	var total int64
+	for i := 0; i < slices; i++ {
+		g.Go(func() error {
+			for hits := range pages {
+				// stop before writing another page once cancelled
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := out.write(ctx, hits); err != nil {
+					return err
+				}
+				atomic.AddInt64(&total, int64(len(hits)))
+				if err := onPage(hits); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	err := g.Wait()
+	return total, err
+}
+
+// rangeQuery matches docs whose date field falls into [start, end). All docs are matched if there is no date field.
+func (d *Dumper) rangeQuery(start, end time.Time) elastic.Query {
+	if d.dateField == nil {
+		return elastic.NewMatchAllQuery()
+	}
+	return elastic.NewBoolQuery().Must(d.dateField.rangeQuery(start, end))
+}
+
+// scrollHits scrolls docs whose date field falls into [start, end) page by page and passes each page
+// to fn. Hits are passed with their metadata, so that _id and _routing of source docs can be kept.
+// slice is id of the sliced scroll, it is ignored if Conf.Slices is not greater than 1.
+// If checkpoints are enabled and the window is not sliced nor unbounded, hits are sorted by date field, so that
+// the date of the last hit of a page can be used as cursor.
+func (d *Dumper) scrollHits(ctx context.Context, start, end time.Time, slice int, fn func(hits []*elastic.SearchHit) error) error {
+	fsc := elastic.NewFetchSourceContext(true)
+	if len(d.Includes) > 0 {
+		fsc = fsc.Include(d.Includes...)
+	}
+	if len(d.Excludes) > 0 {
+		fsc = fsc.Exclude(d.Excludes...)
+	}
+	scrollSize := d.Conf.ScrollSize
+	if scrollSize <= 0 {
+		scrollSize = 1000
+	}
+	scroll := d.SourceClient.Scroll(d.SourceIndex).Type(d.SourceType).Query(d.rangeQuery(start, end)).FetchSourceContext(fsc).Size(scrollSize).KeepAlive(scrollKeepAlive(d.Conf))
+	if d.Conf.Slices > 1 {
+		scroll = scroll.Slice(elastic.NewSliceQuery().Id(slice).Max(d.Conf.Slices))
+	} else if d.Checkpoints != nil && d.dateField != nil {
+		scroll = scroll.Sort(d.Conf.DateField, !d.Conf.Descending)
+	}
+	defer scroll.Clear(context.Background())
+	for {
+		results, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return requestError(err, d.Conf.Input, "scroll source docs error")
+		}
+		if len(results.Hits.Hits) == 0 {
+			continue
+		}
+		if err = fn(results.Hits.Hits); err != nil {
+			return err
+		}
+	}
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond)).In(time.Local)
+}
+
+// bulkSave indexes hits into target index, keeping _id and _routing of each hit.
+// It waits for a free bulk slot before each request, so that at most Conf.BulkConcurrency bulk requests are in flight.
+// Once sent, bulk requests are not cancelled by ctx, so that their results can be recorded on graceful shutdown.
+// Failed requests and docs rejected with retryable status are retried up to Conf.Retries times with backoff,
+// only the rejected docs are sent again. Docs which still fail are written to the dead letter file if
+// Conf.DeadLetter is set, otherwise they are returned in BulkError.
+func (d *Dumper) bulkSave(ctx context.Context, hits []*elastic.SearchHit) error {
+	var (
+		failed     []*elastic.BulkResponseItem
+		failedHits []*elastic.SearchHit
+	)
+	pending := hits
+	for attempt := 1; ; attempt++ {
+		// the slot is held by the request only, other workers may send theirs while this one backs off
+		select {
+		case d.bulkSlots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		bulkRes, err := d.bulk(pending)
+		<-d.bulkSlots
+		if err != nil {
+			if !retryableError(err) || attempt > d.Conf.Retries {
+				if isConnectionError(err) {
+					return err
+				}
+				return &BulkError{Index: d.TargetIndex, Failed: failed, Err: err}
+			}
+		} else {
+			var (
+				retries     []*elastic.SearchHit
+				retryFailed []*elastic.BulkResponseItem
+			)
+			for i, item := range bulkRes.Items {
+				result := item["index"]
+				if result == nil || result.Error == nil {
+					continue
+				}
+				if retryableStatus(result.Status) {
+					retries = append(retries, pending[i])
+					retryFailed = append(retryFailed, result)
+				} else {
+					failedHits = append(failedHits, pending[i])
+					failed = append(failed, result)
+				}
+			}
+			if len(retries) == 0 {
+				break
+			}
+			if attempt > d.Conf.Retries {
+				failedHits = append(failedHits, retries...)
+				failed = append(failed, retryFailed...)
+				break
+			}
+			pending = retries
+		}
+		if err = sleep(ctx, d.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if d.deadLetters != nil {
+		return d.deadLetters.write(failedHits, failed)
+	}
+	return &BulkError{Index: d.TargetIndex, Failed: failed}
+}
+
+// bulk sends hits to target index in one bulk request
+func (d *Dumper) bulk(hits []*elastic.SearchHit) (*elastic.BulkResponse, error) {
+	bulkRequest := d.TargetClient.Bulk().Index(d.TargetIndex).Type(d.TargetType)
+	if d.Conf.Pipelines {
+		// docs have been processed by the copied pipelines in source index, so they are bypassed in target index.
+		// Other runs leave pipelines of target index alone, which also keeps them working with elasticsearch before 6.5.
+		bulkRequest.Pipeline("_none")
+	}
+	for _, hit := range hits {
+		bulkIndexRequest := elastic.NewBulkIndexRequest().Index(d.TargetIndex).Type(d.TargetType).Id(hit.Id).Doc(hit.Source)
+		if stringutils.IsNotEmpty(hit.Routing) {
+			bulkIndexRequest = bulkIndexRequest.Routing(hit.Routing)
+		}
+		bulkRequest.Add(bulkIndexRequest)
+	}
+	bulkRes, err := bulkRequest.Do(context.Background())
+	if err != nil {
+		return nil, requestError(err, d.Conf.Output, "bulk error")
+	}
+	return bulkRes, nil
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"github.com/schollz/progressbar/v3"
+	"sync"
+	"time"
+)
+
+// window is a time range [Start, End) of docs to dump
+type window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// unbounded reports whether the window covers all docs of the index, which is the case if there is no date field
+func (w window) unbounded() bool {
+	return w.Start.IsZero() && w.End.IsZero()
+}
+
+// splitWindows splits [start, end) into windows of step duration. Windows are ordered from start to end,
+// or from end to start if descending is true.
+func splitWindows(start, end time.Time, step time.Duration, descending bool) []window {
+	var windows []window
+	if step <= 0 {
+		if start.Before(end) {
+			windows = append(windows, window{Start: start, End: end})
+		}
+		return windows
+	}
+	if !descending {
+		for start.Before(end) {
+			_end := start.Add(step)
+			if _end.After(end) {
+				_end = end
+			}
+			windows = append(windows, window{Start: start, End: _end})
+			start = _end
+		}
+	} else {
+		for end.After(start) {
+			_start := end.Add(-step)
+			if _start.Before(start) {
+				_start = start
+			}
+			windows = append(windows, window{Start: _start, End: end})
+			end = _start
+		}
+	}
+	return windows
+}
+
+// Summary tells what has been dumped by Dumper.Dump
+type Summary struct {
+	// Docs is number of docs committed to target index
+	Docs int64
+	// DeadLetters is number of docs rejected by target index and written to the dead letter file
+	DeadLetters int64
+	// Windows is number of time windows fully committed
+	Windows int
+	// TotalWindows is number of time windows planned to dump
+	TotalWindows int
+	Elapsed      time.Duration
+	// Interrupted is true if dumping was cancelled before all windows were committed
+	Interrupted bool
+}
+
+func (s Summary) String() string {
+	summary := fmt.Sprintf("dumped %d docs in %s", s.Docs, s.Elapsed.Round(time.Millisecond))
+	if s.TotalWindows > 0 {
+		summary += fmt.Sprintf(", %d/%d time windows committed", s.Windows, s.TotalWindows)
+	}
+	if s.DeadLetters > 0 {
+		summary += fmt.Sprintf(", %d rejected docs written to dead letter file", s.DeadLetters)
+	}
+	if s.Interrupted {
+		summary += ", interrupted"
+	}
+	return summary
+}
+
+// progress is shared by window workers. It reports dumped docs to the progress bar and advances
+// the checkpoint in window order, so a window is recorded as committed only after all windows
+// before it have been committed, no matter in which order workers finish them.
+type progress struct {
+	mu         sync.Mutex
+	bar        *progressbar.ProgressBar
+	store      *CheckpointStore
+	checkpoint Checkpoint
+	windows    []window
+	done       []bool
+	// durable is false if committed pages are lost unless their window is committed,
+	// then checkpoints are saved per window only
+	durable bool
+	// pending counts docs of windows whose pages are not durable
+	pending []int64
+	// head is index of the first window which has not been committed
+	head int
+	// docs and committed count what has been dumped by this run
+	docs      int64
+	committed int
+}
+
+func newProgress(bar *progressbar.ProgressBar, store *CheckpointStore, checkpoint Checkpoint, windows []window, durable bool) *progress {
+	return &progress{
+		bar:        bar,
+		store:      store,
+		checkpoint: checkpoint,
+		windows:    windows,
+		done:       make([]bool, len(windows)),
+		durable:    durable,
+		pending:    make([]int64, len(windows)),
+	}
+}
+
+// page is called after a page of n hits of the i-th window has been committed,
+// cursor is date of the last hit of the page
+func (p *progress) page(i, n int, cursor *time.Time) error {
+	p.bar.Add(n)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.docs += int64(n)
+	if p.store == nil {
+		return nil
+	}
+	if !p.durable {
+		p.pending[i] += int64(n)
+		return nil
+	}
+	p.checkpoint.Docs += int64(n)
+	// only the cursor of the head window is meaningful for resuming
+	if i == p.head {
+		p.checkpoint.Cursor = cursor
+	}
+	return p.store.Save(p.checkpoint)
+}
+
+// window is called after all hits of the i-th window have been committed
+func (p *progress) window(i int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed++
+	if p.store == nil {
+		return nil
+	}
+	p.done[i] = true
+	p.checkpoint.Docs += p.pending[i]
+	if i != p.head {
+		return nil
+	}
+	for p.head < len(p.windows) && p.done[p.head] {
+		w := p.windows[p.head]
+		if !w.unbounded() {
+			p.checkpoint.WindowStart = &w.Start
+			p.checkpoint.WindowEnd = &w.End
+		}
+		p.checkpoint.Cursor = nil
+		p.head++
+	}
+	p.checkpoint.Completed = p.head == len(p.windows)
+	return p.store.Save(p.checkpoint)
+}
+
+// summary returns what has been dumped so far
+func (p *progress) summary() Summary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Summary{
+		Docs:         p.docs,
+		Windows:      p.committed,
+		TotalWindows: len(p.windows),
+	}
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"github.com/schollz/progressbar/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProgress_OutOfOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewCheckpointStore(filepath.Join(dir, "checkpoint.json"))
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	windows := splitWindows(start, start.Add(30*24*time.Hour), 240*time.Hour, false)
+	require.Len(t, windows, 3)
+	p := newProgress(progressbar.DefaultSilent(-1), store, Checkpoint{Source: "source", Target: "target"}, windows, true)
+
+	saved := func(windowEnd, cursor *time.Time, completed bool, docs int64) {
+		t.Helper()
+		checkpoint, err := store.Load("source", "target")
+		require.NoError(t, err)
+		require.NotNil(t, checkpoint)
+		assert.Equal(t, windowEnd, checkpoint.WindowEnd)
+		assert.Equal(t, cursor, checkpoint.Cursor)
+		assert.Equal(t, completed, checkpoint.Completed)
+		assert.Equal(t, docs, checkpoint.Docs)
+	}
+	cursor := func(w window) *time.Time {
+		c := w.Start.Add(time.Hour)
+		return &c
+	}
+
+	// the cursor of a window behind the head is not saved
+	require.NoError(t, p.page(1, 5, cursor(windows[1])))
+	saved(nil, nil, false, 5)
+	require.NoError(t, p.page(0, 3, cursor(windows[0])))
+	saved(nil, cursor(windows[0]), false, 8)
+
+	// the head does not move past unfinished windows
+	require.NoError(t, p.window(1))
+	saved(nil, cursor(windows[0]), false, 8)
+	require.NoError(t, p.window(0))
+	saved(&windows[1].End, nil, false, 8)
+
+	require.NoError(t, p.page(2, 2, cursor(windows[2])))
+	saved(&windows[1].End, cursor(windows[2]), false, 10)
+	require.NoError(t, p.window(2))
+	saved(&windows[2].End, nil, true, 10)
+
+	summary := p.summary()
+	assert.Equal(t, int64(10), summary.Docs)
+	assert.Equal(t, 3, summary.Windows)
+	assert.Equal(t, 3, summary.TotalWindows)
+}
+
+func TestProgress_NotDurable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "esdump")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewCheckpointStore(filepath.Join(dir, "checkpoint.json"))
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	windows := splitWindows(start, start.Add(20*24*time.Hour), 240*time.Hour, false)
+	p := newProgress(progressbar.DefaultSilent(-1), store, Checkpoint{Source: "source", Target: "target"}, windows, false)
+
+	// pages are only counted once their window is committed, and no cursor is saved
+	cursor := start.Add(time.Hour)
+	require.NoError(t, p.page(1, 4, &cursor))
+	require.NoError(t, p.page(0, 3, &cursor))
+	checkpoint, err := store.Load("source", "target")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	require.NoError(t, p.window(1))
+	require.NoError(t, p.window(0))
+	checkpoint, err = store.Load("source", "target")
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, &windows[1].End, checkpoint.WindowEnd)
+	assert.Nil(t, checkpoint.Cursor)
+	assert.True(t, checkpoint.Completed)
+	assert.Equal(t, int64(7), checkpoint.Docs)
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// backoff returns how long to wait before the attempt-th retry. It grows exponentially from Conf.RetryBackoff
+// up to Conf.RetryMaxBackoff, and a random jitter of up to half of it is subtracted, so that workers rejected
+// at the same time don't retry at the same time.
+func (d *Dumper) backoff(attempt int) time.Duration {
+	return retryBackoff(d.Conf, attempt)
+}
+
+func retryBackoff(conf Config, attempt int) time.Duration {
+	wait := conf.RetryBackoff
+	if wait <= 0 {
+		wait = 500 * time.Millisecond
+	}
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if conf.RetryMaxBackoff > 0 && wait >= conf.RetryMaxBackoff {
+			wait = conf.RetryMaxBackoff
+			break
+		}
+	}
+	half := int64(wait / 2)
+	if half <= 0 {
+		return wait
+	}
+	return wait - time.Duration(rand.Int63n(half))
+}
+
+// scrollKeepAlive is how long scroll contexts are kept between pages. A page may wait for a bulk slot held by
+// a request retrying with backoff, then retry with backoff itself, so twice the longest backoff budget of Conf.Retries
+// is added to a minute, to keep the scroll context from expiring before the next page is fetched.
+func scrollKeepAlive(conf Config) string {
+	keepAlive := time.Minute
+	wait := conf.RetryBackoff
+	if wait <= 0 {
+		wait = 500 * time.Millisecond
+	}
+	for i := 0; i < conf.Retries; i++ {
+		if conf.RetryMaxBackoff > 0 && wait > conf.RetryMaxBackoff {
+			wait = conf.RetryMaxBackoff
+		}
+		keepAlive += 2 * wait
+		wait *= 2
+	}
+	return fmt.Sprintf("%ds", int64(math.Ceil(keepAlive.Seconds())))
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryableStatus reports whether a request or a bulk item failed with status code may succeed if retried,
+// e.g. 429 caused by es_rejected_execution_exception
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableError reports whether a failed request may succeed if retried
+func retryableError(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	var elasticErr *elastic.Error
+	if errors.As(err, &elasticErr) {
+		return retryableStatus(elasticErr.Status)
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScrollKeepAlive(t *testing.T) {
+	assert.Equal(t, "60s", scrollKeepAlive(Config{}))
+	// 2 * (500ms + 1s + 2s)
+	assert.Equal(t, "67s", scrollKeepAlive(Config{Retries: 3}))
+	// 2 * (1s + 2s + 2s + 2s)
+	assert.Equal(t, "74s", scrollKeepAlive(Config{Retries: 4, RetryBackoff: time.Second, RetryMaxBackoff: 2 * time.Second}))
+	// uncapped backoffs of many retries outlast a minute by far
+	assert.Equal(t, "1083s", scrollKeepAlive(Config{Retries: 10}))
+}
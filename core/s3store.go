@@ -0,0 +1,369 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// s3PartSize is size of parts of multipart uploads, so a window file can be up to 160GB before compression
+const s3PartSize = 16 << 20
+
+// s3Store keeps files under a prefix of a S3 bucket
+type s3Store struct {
+	client *minio.Core
+	bucket string
+	prefix string
+	conf   Config
+	// url is the redacted url of the store, for error messages
+	url string
+	mu  sync.Mutex
+	// uploads are ids of multipart uploads left by interrupted runs by key, found by cleanup
+	uploads map[string]string
+}
+
+// newS3Store connects to the bucket of s3://bucket/prefix. Endpoint and region are given by endpoint and region
+// query parameters, endpoint defaults to AWS S3, and insecure=true switches to plain http. Credentials are taken from
+// user info of the url, or from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, MINIO_ROOT_USER and MINIO_ROOT_PASSWORD,
+// ~/.aws/credentials and IAM role in order.
+func newS3Store(u *url.URL, conf Config) (*s3Store, error) {
+	if u.Host == "" {
+		return nil, &ParseError{Field: "url", Value: redactURL(u.String()), Err: errors.New("bucket should not be empty")}
+	}
+	query := u.Query()
+	endpoint := query.Get("endpoint")
+	secure := true
+	if query.Get("insecure") != "" {
+		insecure, err := strconv.ParseBool(query.Get("insecure"))
+		if err != nil {
+			return nil, &ParseError{Field: "insecure", Value: query.Get("insecure"), Err: err}
+		}
+		secure = !insecure
+	}
+	switch {
+	case endpoint == "":
+		endpoint = "s3.amazonaws.com"
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint, secure = strings.TrimPrefix(endpoint, "http://"), false
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint = strings.TrimPrefix(endpoint, "https://")
+	}
+	var creds *credentials.Credentials
+	if username := u.User.Username(); username != "" {
+		password, _ := u.User.Password()
+		creds = credentials.NewStaticV4(username, password, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	client, err := minio.NewCore(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: query.Get("region"),
+	})
+	if err != nil {
+		return nil, &ConnectionError{URL: redactURL(u.String()), Err: err}
+	}
+	return &s3Store{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		conf:   conf,
+		url:    redactURL(u.String()),
+	}, nil
+}
+
+func (s *s3Store) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *s3Store) location(name string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.key(name))
+}
+
+// error wraps err returned by a request on the named file, a missing file is reported as os.ErrNotExist
+func (s *s3Store) error(err error, name string, message string) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return &os.PathError{Op: "open", Path: s.location(name), Err: os.ErrNotExist}
+	}
+	return requestError(err, s.url, message)
+}
+
+// retry calls fn until it succeeds or Conf.Retries retries have failed, so that a failed part upload
+// is resumed from that part rather than restarting the whole file
+func (s *s3Store) retry(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > s.conf.Retries || !s3Retryable(err) {
+			return err
+		}
+		if err = sleep(ctx, retryBackoff(s.conf, attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+func s3Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	status := minio.ToErrorResponse(err).StatusCode
+	return retryableStatus(status) || status == http.StatusInternalServerError
+}
+
+// input returns the store itself if the prefix is a dump, or the store of its parent along with the object name
+// if the prefix is a single object
+func (s *s3Store) input(ctx context.Context) (store, string, error) {
+	if s.prefix == "" {
+		return s, "", nil
+	}
+	_, err := s.client.StatObject(ctx, s.bucket, s.prefix, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return s, "", nil
+		}
+		return nil, "", s.error(err, "", "stat input error")
+	}
+	parent := &s3Store{
+		client: s.client,
+		bucket: s.bucket,
+		prefix: path.Dir(s.prefix),
+		conf:   s.conf,
+		url:    s.url,
+	}
+	if parent.prefix == "." {
+		parent.prefix = ""
+	}
+	return parent, path.Base(s.prefix), nil
+}
+
+func (s *s3Store) readFile(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, s.error(err, name, "read file error")
+	}
+	return data, nil
+}
+
+// writeFile puts the whole file in one request, which replaces the object atomically
+func (s *s3Store) writeFile(ctx context.Context, name string, data []byte) error {
+	return s.retry(ctx, func() error {
+		_, err := s.client.Client.PutObject(ctx, s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+		if err != nil {
+			return s.error(err, name, "put object error")
+		}
+		return nil
+	})
+}
+
+func (s *s3Store) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, _, _, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.error(err, name, "get object error")
+	}
+	return reader, nil
+}
+
+func (s *s3Store) create(ctx context.Context, name string) (storeWriter, error) {
+	return &s3Writer{
+		ctx:   ctx,
+		store: s,
+		name:  name,
+	}, nil
+}
+
+// cleanup keeps the latest multipart upload of each data file left by an interrupted run for s3Writer to resume,
+// and aborts the others. Uploads of other keys under the prefix or in the bucket are left alone.
+func (s *s3Store) cleanup(ctx context.Context) error {
+	prefix := s.key(dataFilePrefix)
+	var keyMarker, uploadIDMarker string
+	latest := make(map[string]minio.ObjectMultipartInfo)
+	for {
+		// the delimiter leaves out keys nested under the prefix
+		result, err := s.client.ListMultipartUploads(ctx, s.bucket, prefix, keyMarker, uploadIDMarker, "/", 1000)
+		if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+			// some S3 compatible stores answer so if there is no upload at all
+			break
+		}
+		if err != nil {
+			return s.error(err, "", "list multipart uploads error")
+		}
+		for _, upload := range result.Uploads {
+			last, ok := latest[upload.Key]
+			if ok && !upload.Initiated.After(last.Initiated) {
+				last = upload
+			} else {
+				latest[upload.Key] = upload
+			}
+			if !ok {
+				continue
+			}
+			if err = s.client.AbortMultipartUpload(ctx, s.bucket, last.Key, last.UploadID); err != nil {
+				return s.error(err, "", "abort multipart upload error")
+			}
+		}
+		if !result.IsTruncated {
+			break
+		}
+		keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads = make(map[string]string, len(latest))
+	for key, upload := range latest {
+		s.uploads[key] = upload.UploadID
+	}
+	return nil
+}
+
+// resumeUpload claims the upload of key left by an interrupted run and returns its uploaded parts by part number
+func (s *s3Store) resumeUpload(ctx context.Context, key string) (string, map[int]minio.ObjectPart, error) {
+	s.mu.Lock()
+	uploadID := s.uploads[key]
+	delete(s.uploads, key)
+	s.mu.Unlock()
+	if uploadID == "" {
+		return "", nil, nil
+	}
+	parts := make(map[int]minio.ObjectPart)
+	marker := 0
+	for {
+		result, err := s.client.ListObjectParts(ctx, s.bucket, key, uploadID, marker, 1000)
+		if minio.ToErrorResponse(err).Code == "NoSuchUpload" {
+			// completed or aborted meanwhile
+			return "", nil, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		for _, part := range result.ObjectParts {
+			parts[part.PartNumber] = part
+		}
+		if !result.IsTruncated {
+			return uploadID, parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// s3Writer buffers written data and uploads it part by part once a part is filled. The object appears
+// only after the multipart upload has been completed on commit. Files smaller than a part are put in one request.
+type s3Writer struct {
+	ctx      context.Context
+	store    *s3Store
+	name     string
+	buf      bytes.Buffer
+	uploadID string
+	parts    []minio.CompletePart
+	// uploaded are parts uploaded by an interrupted run to the resumed upload, by part number
+	uploaded map[int]minio.ObjectPart
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for w.buf.Len() >= s3PartSize {
+		if err := w.uploadPart(w.buf.Next(s3PartSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	s := w.store
+	key := s.key(w.name)
+	if w.uploadID == "" {
+		err := s.retry(w.ctx, func() error {
+			var err error
+			w.uploadID, w.uploaded, err = s.resumeUpload(w.ctx, key)
+			return err
+		})
+		if err != nil {
+			return s.error(err, w.name, "list parts error")
+		}
+	}
+	if w.uploadID == "" {
+		err := s.retry(w.ctx, func() error {
+			var err error
+			w.uploadID, err = s.client.NewMultipartUpload(w.ctx, s.bucket, key, minio.PutObjectOptions{})
+			return err
+		})
+		if err != nil {
+			return s.error(err, w.name, "create multipart upload error")
+		}
+	}
+	partID := len(w.parts) + 1
+	sum := md5.Sum(data)
+	if part, ok := w.uploaded[partID]; ok && part.Size == int64(len(data)) && strings.Trim(part.ETag, `"`) == hex.EncodeToString(sum[:]) {
+		// the interrupted run uploaded the same data, a part is replaced if it differs
+		w.parts = append(w.parts, minio.CompletePart{PartNumber: partID, ETag: part.ETag})
+		return nil
+	}
+	var part minio.ObjectPart
+	err := s.retry(w.ctx, func() error {
+		var err error
+		part, err = s.client.PutObjectPart(w.ctx, s.bucket, key, w.uploadID, partID, bytes.NewReader(data), int64(len(data)), "", "", nil)
+		return err
+	})
+	if err != nil {
+		return s.error(err, w.name, "upload part error")
+	}
+	w.parts = append(w.parts, minio.CompletePart{PartNumber: partID, ETag: part.ETag})
+	return nil
+}
+
+func (w *s3Writer) commit(ctx context.Context) error {
+	s := w.store
+	if w.uploadID == "" {
+		return s.writeFile(ctx, w.name, w.buf.Bytes())
+	}
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	err := s.retry(ctx, func() error {
+		_, err := s.client.CompleteMultipartUpload(ctx, s.bucket, s.key(w.name), w.uploadID, w.parts, minio.PutObjectOptions{})
+		return err
+	})
+	if err != nil {
+		return s.error(err, w.name, "complete multipart upload error")
+	}
+	return nil
+}
+
+func (w *s3Writer) abort() {
+	if w.uploadID == "" {
+		return
+	}
+	s := w.store
+	s.client.AbortMultipartUpload(context.Background(), s.bucket, s.key(w.name), w.uploadID)
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// nonPortableSettings are settings of source index which are assigned by the cluster on creation, or private
+// settings left by shrinking, splitting or closing it, they are rejected or meaningless on another index.
+// Write blocks, often set on source index while it is dumped, would make target index reject the copied docs.
+var nonPortableSettings = []string{
+	"index.uuid",
+	"index.creation_date",
+	"index.version",
+	"index.provided_name",
+	"index.resize",
+	"index.shrink",
+	"index.routing.allocation.initial_recovery",
+	"index.verified_before_close",
+	"index.blocks.write",
+	"index.blocks.read_only",
+	"index.blocks.read_only_allow_delete",
+}
+
+// parseSettings parses comma separated key=value pairs of Config.Settings. Keys may leave out the index. prefix,
+// an empty value removes the setting.
+func parseSettings(s string) (map[string]string, error) {
+	pairs, err := parsePairs("settings", s)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string, len(pairs))
+	for key, value := range pairs {
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// parsePairs parses comma separated key=value pairs of the config field, values may be empty
+func parsePairs(field, s string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, &ParseError{Field: field, Value: s, Err: errors.Errorf("%q should be key=value", pair)}
+		}
+		pairs[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return pairs, nil
+}
+
+// targetSettings returns a copy of settings of source index without non-portable settings and with
+// overrides of Config.Settings applied, nil if there is nothing left to set. Pipeline settings are left out
+// unless Config.Pipelines is set, as target cluster may not have the pipelines they name.
+func (d *Dumper) targetSettings(settings map[string]interface{}) map[string]interface{} {
+	// settings may be nested as returned by elasticsearch or flat as written by hand, they are nested for editing
+	nested := nestSettings(settings)
+	for _, key := range nonPortableSettings {
+		deleteSetting(nested, key)
+	}
+	if !d.Conf.Pipelines {
+		for _, setting := range pipelineSettings {
+			deleteSetting(nested, "index."+setting)
+		}
+	}
+	for key, value := range d.settings {
+		if value == "" {
+			deleteSetting(nested, key)
+			continue
+		}
+		setSetting(nested, key, value)
+	}
+	if len(nested) == 0 {
+		return nil
+	}
+	return nested
+}
+
+// nestSettings returns a deep copy of settings with dotted keys expanded into nested objects
+func nestSettings(settings map[string]interface{}) map[string]interface{} {
+	nested := make(map[string]interface{})
+	var walk func(prefix string, object map[string]interface{})
+	walk = func(prefix string, object map[string]interface{}) {
+		for key, value := range object {
+			if child, ok := value.(map[string]interface{}); ok {
+				walk(prefix+key+".", child)
+				continue
+			}
+			setSetting(nested, prefix+key, value)
+		}
+	}
+	walk("", settings)
+	return nested
+}
+
+func setSetting(settings map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	object := settings
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := object[part].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			object[part] = child
+		}
+		object = child
+	}
+	object[parts[len(parts)-1]] = value
+}
+
+// deleteSetting deletes the setting or the object of settings at key, objects left empty are deleted as well
+func deleteSetting(settings map[string]interface{}, key string) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) == 1 {
+		delete(settings, key)
+		return
+	}
+	child, ok := settings[parts[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteSetting(child, parts[1])
+	if len(child) == 0 {
+		delete(settings, parts[0])
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"github.com/Jeffail/gabs/v2"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/unionj-cloud/go-doudou/toolkit/stringutils"
+	"github.com/wubin1989/go-esutils/v2"
+	"net/http"
+	"time"
+)
+
+// indexMeta is mapping and settings of source index
+type indexMeta struct {
+	Index string
+	Type  string
+	// Mapping is mapping of the type, i.e. the object having properties
+	Mapping map[string]interface{}
+	// Settings is settings of the index, i.e. the object having index key
+	Settings map[string]interface{}
+	// Aliases are definitions of aliases pointing to the index by alias name, nil unless Conf.Aliases is set
+	Aliases map[string]interface{}
+}
+
+// sink is where dumped mapping, settings and docs go
+type sink interface {
+	// putIndex creates target index from mapping and settings of source index
+	putIndex(ctx context.Context, meta indexMeta) error
+	// openWindow returns a writer for docs of the window
+	openWindow(ctx context.Context, w window) (windowWriter, error)
+	// pagesDurable reports whether written pages survive a crash before their window is committed,
+	// checkpoint cursors are saved only if they do
+	pagesDurable() bool
+	// committed returns docs of the window if it has been committed by last run, such windows are skipped on resume
+	committed(w window) (int64, bool)
+	// flush is called after all windows have been committed
+	flush(ctx context.Context) error
+}
+
+// windowWriter writes docs of one window, write may be called by several goroutines concurrently
+type windowWriter interface {
+	write(ctx context.Context, hits []*elastic.SearchHit) error
+	// commit is called after all docs of the window have been written
+	commit(ctx context.Context) error
+	// abort is called instead of commit if dumping the window failed
+	abort()
+}
+
+// esSink writes to target index of TargetClient
+type esSink struct {
+	d *Dumper
+}
+
+// putIndex creates target index with settings, mapping and aliases in one request, so that the mapping may refer to
+// analyzers and normalizers defined by the settings. If target index exists, only the mapping and aliases are put,
+// as analysis settings cannot be changed on an open index.
+func (s *esSink) putIndex(ctx context.Context, meta indexMeta) error {
+	d := s.d
+	existsCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	exists, err := d.TargetClient.IndexExists(d.TargetIndex).Do(existsCtx)
+	if err != nil {
+		return requestError(err, d.Conf.Output, "check target index error")
+	}
+	if !exists {
+		body := make(map[string]interface{})
+		if meta.Settings != nil {
+			body["settings"] = meta.Settings
+		}
+		if meta.Mapping != nil {
+			// e.g. docs imported from a single NDJSON file have no mapping, which is left to dynamic mapping
+			body["mappings"] = meta.Mapping
+		}
+		if meta.Aliases != nil {
+			body["aliases"] = meta.Aliases
+		}
+		createCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		_, err := d.TargetClient.CreateIndex(d.TargetIndex).BodyJson(body).Do(createCtx)
+		if err == nil {
+			return nil
+		}
+		if !isAlreadyExists(err) {
+			if elastic.IsStatusCode(err, http.StatusBadRequest) {
+				// e.g. the mapping refers to an analyzer missing from settings
+				return &MappingError{Index: d.TargetIndex, Err: errors.Wrap(err, "create target index error")}
+			}
+			return requestError(err, d.Conf.Output, "create target index error")
+		}
+		// created by another run in between, only the mapping and aliases are put below
+	}
+	if meta.Aliases != nil {
+		if err := s.putAliases(ctx, meta.Aliases); err != nil {
+			return err
+		}
+	}
+	if meta.Mapping == nil {
+		return nil
+	}
+
+	targetOptions := []esutils.EsOption{esutils.WithClient(d.TargetClient)}
+	if stringutils.IsNotEmpty(d.TargetType) {
+		targetOptions = append(targetOptions, esutils.WithType(d.TargetType))
+	}
+	targetEs := esutils.NewEs(d.TargetIndex, targetOptions...)
+	putCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := targetEs.PutMappingJson(putCtx, gabs.Wrap(meta.Mapping).String()); err != nil {
+		if err = requestError(err, d.Conf.Output, "put mapping error"); isConnectionError(err) {
+			return err
+		}
+		return &MappingError{Index: d.TargetIndex, Err: err}
+	}
+	return nil
+}
+
+// isAlreadyExists reports whether a create index request failed as the index exists
+func isAlreadyExists(err error) bool {
+	var elasticErr *elastic.Error
+	return errors.As(err, &elasticErr) && elasticErr.Details != nil &&
+		elasticErr.Details.Type == "resource_already_exists_exception"
+}
+
+func (s *esSink) openWindow(ctx context.Context, w window) (windowWriter, error) {
+	return s, nil
+}
+
+func (s *esSink) pagesDurable() bool {
+	return true
+}
+
+// committed is false as progress of the target index is only known from checkpoints
+func (s *esSink) committed(w window) (int64, bool) {
+	return 0, false
+}
+
+func (s *esSink) flush(ctx context.Context) error {
+	if _, err := s.d.TargetClient.Refresh(s.d.TargetIndex).Do(ctx); err != nil {
+		return requestError(err, s.d.Conf.Output, "refresh target index error")
+	}
+	return nil
+}
+
+// write bulk saves hits, every bulk request is committed on its own
+func (s *esSink) write(ctx context.Context, hits []*elastic.SearchHit) error {
+	return s.d.bulkSave(ctx, hits)
+}
+
+func (s *esSink) commit(ctx context.Context) error {
+	return nil
+}
+
+func (s *esSink) abort() {
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"context"
+	"github.com/Jeffail/gabs/v2"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/unionj-cloud/go-doudou/toolkit/stringutils"
+	"github.com/wubin1989/go-esutils/v2"
+	"time"
+)
+
+// source is where mapping, settings and docs are read from
+type source interface {
+	// meta returns mapping and settings of source index, Mapping is nil if there is none
+	meta(ctx context.Context) (indexMeta, error)
+	// plan returns windows to dump and how many docs they hold, -1 means unknown.
+	// Windows committed by last run are left out if last is not nil.
+	plan(ctx context.Context, last *Checkpoint) ([]window, int64, error)
+	// read passes docs of window w to fn page by page. If Conf.Slices is greater than 1, read is called
+	// by that many goroutines with slice from 0 to Conf.Slices-1, each reading a part of the window.
+	read(ctx context.Context, w window, slice int, fn func(hits []*elastic.SearchHit) error) error
+	// count returns number of docs held by source regardless of date range and filters, -1 means unknown
+	count(ctx context.Context) (int64, error)
+}
+
+// esSource reads from source index of SourceClient
+type esSource struct {
+	d *Dumper
+}
+
+func (s *esSource) meta(ctx context.Context) (indexMeta, error) {
+	d := s.d
+	meta := indexMeta{
+		Index: d.SourceIndex,
+		Type:  d.SourceType,
+	}
+	sourceOptions := []esutils.EsOption{esutils.WithClient(d.SourceClient)}
+	if stringutils.IsNotEmpty(d.SourceType) {
+		sourceOptions = append(sourceOptions, esutils.WithType(d.SourceType))
+	}
+	sourceEs := esutils.NewEs(d.SourceIndex, sourceOptions...)
+
+	getCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	mapping, err := sourceEs.GetMapping(getCtx)
+	if err != nil {
+		return meta, requestError(err, d.Conf.Input, "get mapping of source index error")
+	}
+	sourceType := d.SourceType
+	if stringutils.IsEmpty(sourceType) {
+		sourceType = "_doc"
+	}
+	var ok bool
+	if meta.Mapping, ok = gabs.Wrap(mapping).Search(d.SourceIndex, "mappings", sourceType).Data().(map[string]interface{}); !ok {
+		return meta, &MappingError{Index: d.SourceIndex, Err: errors.Errorf("mapping of type %s not found", sourceType)}
+	}
+
+	settingsCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	settings, err := d.SourceClient.IndexGetSettings(d.SourceIndex).Do(settingsCtx)
+	if err != nil {
+		return meta, requestError(err, d.Conf.Input, "get settings of source index error")
+	}
+	if resp, ok := settings[d.SourceIndex]; ok && resp != nil {
+		meta.Settings = resp.Settings
+	}
+
+	if d.Conf.Aliases {
+		aliasCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		index, err := d.SourceClient.IndexGet(d.SourceIndex).Do(aliasCtx)
+		if err != nil {
+			return meta, requestError(err, d.Conf.Input, "get aliases of source index error")
+		}
+		if resp, ok := index[d.SourceIndex]; ok && resp != nil {
+			meta.Aliases = resp.Aliases
+		}
+	}
+	return meta, nil
+}
+
+// plan splits the date range into windows of Conf.Step, and counts docs in the range
+func (s *esSource) plan(ctx context.Context, last *Checkpoint) ([]window, int64, error) {
+	d := s.d
+	var (
+		windows []window
+		query   elastic.Query
+	)
+	if stringutils.IsEmpty(d.Conf.DateField) {
+		if d.StartTime != nil || d.EndTime != nil {
+			return nil, 0, errors.New("start and end dates require date field")
+		}
+		// without date field all docs are dumped as one window, which can only be resumed as a whole
+		if last == nil || !last.Completed {
+			windows = []window{{}}
+		}
+		query = elastic.NewMatchAllQuery()
+	} else {
+		resolveCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		var err error
+		if d.dateField, err = d.resolveDateField(resolveCtx); err != nil {
+			return nil, 0, err
+		}
+		start, end, err := d.timeRange(ctx, last)
+		if err != nil {
+			return nil, 0, err
+		}
+		windows = splitWindows(start, end, d.Conf.Step, d.Conf.Descending)
+		query = d.rangeQuery(start, end)
+	}
+
+	var total int64
+	if len(windows) > 0 {
+		countCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		var err error
+		total, err = d.SourceClient.Count(d.SourceIndex).Type(d.SourceType).Query(query).Do(countCtx)
+		if err != nil {
+			return nil, 0, requestError(err, d.Conf.Input, "count source docs error")
+		}
+	}
+	return windows, total, nil
+}
+
+func (s *esSource) read(ctx context.Context, w window, slice int, fn func(hits []*elastic.SearchHit) error) error {
+	return s.d.scrollHits(ctx, w.Start, w.End, slice, fn)
+}
+
+func (s *esSource) count(ctx context.Context) (int64, error) {
+	d := s.d
+	countCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	total, err := d.SourceClient.Count(d.SourceIndex).Type(d.SourceType).Do(countCtx)
+	if err != nil {
+		return 0, requestError(err, d.Conf.Input, "count source docs error")
+	}
+	return total, nil
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"regexp"
+	"strings"
+)
+
+// sourceFilter filters fields of _source the way source filtering of elasticsearch does. Patterns are matched
+// against full dotted paths of fields and * matches any characters. Objects matched by includes are kept
+// as a whole except for excluded fields inside.
+type sourceFilter struct {
+	includes []*regexp.Regexp
+	excludes []*regexp.Regexp
+}
+
+func newSourceFilter(includes, excludes []string) *sourceFilter {
+	return &sourceFilter{
+		includes: compilePatterns(includes),
+		excludes: compilePatterns(excludes),
+	}
+}
+
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		compiled = append(compiled, regexp.MustCompile(expr))
+	}
+	return compiled
+}
+
+func matchAny(patterns []*regexp.Regexp, path string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// empty reports whether the filter keeps all fields
+func (f *sourceFilter) empty() bool {
+	return len(f.includes) == 0 && len(f.excludes) == 0
+}
+
+// filter returns a copy of source holding included fields which are not excluded
+func (f *sourceFilter) filter(source map[string]interface{}) map[string]interface{} {
+	return f.filterObject("", source, len(f.includes) == 0)
+}
+
+func (f *sourceFilter) filterObject(prefix string, object map[string]interface{}, included bool) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range object {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if matchAny(f.excludes, path) {
+			continue
+		}
+		if filtered, ok := f.filterValue(path, value, included || matchAny(f.includes, path)); ok {
+			result[key] = filtered
+		}
+	}
+	return result
+}
+
+// filterValue filters the field at path, it returns false if nothing of the field is kept
+func (f *sourceFilter) filterValue(path string, value interface{}, included bool) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		filtered := f.filterObject(path, v, included)
+		return filtered, len(filtered) > 0 || (included && len(v) == 0)
+	case []interface{}:
+		filtered := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if kept, ok := f.filterValue(path, item, included); ok {
+				filtered = append(filtered, kept)
+			}
+		}
+		return filtered, len(filtered) > 0 || (included && len(v) == 0)
+	default:
+		return value, included
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// store holds files of a file dump, it is a local directory or a prefix of a S3 bucket
+type store interface {
+	// readFile returns content of the named file, the error satisfies os.IsNotExist if there is no such file
+	readFile(ctx context.Context, name string) ([]byte, error)
+	// writeFile replaces the named file with data, readers never see it half written
+	writeFile(ctx context.Context, name string, data []byte) error
+	// open opens the named file for reading
+	open(ctx context.Context, name string) (io.ReadCloser, error)
+	// create returns a writer of the named file, the file appears only after commit has returned
+	create(ctx context.Context, name string) (storeWriter, error)
+	// cleanup removes leftovers of data files created but neither committed nor aborted, e.g. by a killed run
+	cleanup(ctx context.Context) error
+	// location returns where the named file is, for error messages
+	location(name string) string
+}
+
+// storeWriter writes a file of store
+type storeWriter interface {
+	io.Writer
+	commit(ctx context.Context) error
+	// abort discards what has been written
+	abort()
+}
+
+// isStoreScheme reports whether u points to a file dump rather than an elasticsearch index
+func isStoreScheme(u *url.URL) bool {
+	return u.Scheme == "file" || u.Scheme == "s3"
+}
+
+// openStore returns the store of a file dump at u, the local directory is created if create is true
+func openStore(u *url.URL, conf Config, create bool) (store, error) {
+	if u.Scheme == "s3" {
+		return newS3Store(u, conf)
+	}
+	dir := fileDir(u)
+	if dir == "" {
+		return nil, &ParseError{Field: "url", Value: u.String(), Err: errors.New("directory should not be empty")}
+	}
+	if create {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrap(err, "create output directory error")
+		}
+	}
+	return &localStore{dir: dir}, nil
+}
+
+// openInput returns the store of the file dump at u. If u points to a single file rather than a dump,
+// the store of its parent is returned along with its name.
+func openInput(ctx context.Context, u *url.URL, conf Config) (store, string, error) {
+	if u.Scheme == "s3" {
+		s, err := newS3Store(u, conf)
+		if err != nil {
+			return nil, "", err
+		}
+		return s.input(ctx)
+	}
+	path := fileDir(u)
+	if path == "" {
+		return nil, "", &ParseError{Field: "input", Value: u.String(), Err: errors.New("path should not be empty")}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "open input error")
+	}
+	if info.IsDir() {
+		return &localStore{dir: path}, "", nil
+	}
+	return &localStore{dir: filepath.Dir(path)}, filepath.Base(path), nil
+}
+
+// fileDir returns the local path of a file:// url, both file:///abs/dir and file://rel/dir are accepted
+func fileDir(u *url.URL) string {
+	return filepath.FromSlash(u.Host + u.Path)
+}
+
+// localStore keeps files in a local directory
+type localStore struct {
+	dir string
+}
+
+func (s *localStore) location(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *localStore) readFile(ctx context.Context, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.location(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "call ReadFile() error")
+	}
+	return data, nil
+}
+
+func (s *localStore) writeFile(ctx context.Context, name string, data []byte) error {
+	return writeFileAtomic(s.location(name), data)
+}
+
+func (s *localStore) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	file, err := os.Open(s.location(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "open data file error")
+	}
+	return file, nil
+}
+
+// create writes into a .part file which is renamed on commit
+func (s *localStore) create(ctx context.Context, name string) (storeWriter, error) {
+	file, err := os.Create(s.location(name) + ".part")
+	if err != nil {
+		return nil, errors.Wrap(err, "create data file error")
+	}
+	return &localWriter{
+		File: file,
+		name: s.location(name),
+	}, nil
+}
+
+// cleanup removes .part files of data files, other .part files in the directory are not ours
+func (s *localStore) cleanup(ctx context.Context) error {
+	parts, err := filepath.Glob(filepath.Join(s.dir, dataFilePrefix+"*.part"))
+	if err != nil {
+		return errors.Wrap(err, "call Glob() error")
+	}
+	for _, part := range parts {
+		if err = os.Remove(part); err != nil {
+			return errors.Wrap(err, "call Remove() error")
+		}
+	}
+	return nil
+}
+
+type localWriter struct {
+	*os.File
+	name string
+}
+
+func (w *localWriter) commit(ctx context.Context) error {
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return errors.Wrap(err, "call Sync() error")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "call Close() error")
+	}
+	if err := os.Rename(w.Name(), w.name); err != nil {
+		return errors.Wrap(err, "call Rename() error")
+	}
+	return nil
+}
+
+func (w *localWriter) abort() {
+	w.Close()
+	os.Remove(w.Name())
+}
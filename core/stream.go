@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// stdio is the input or output url standing for stdin or stdout
+const stdio = "-"
+
+// streamSink writes docs to stdout, in _bulk API format by default, so that they can be piped to another process.
+// Mapping and settings are not written, as the stream carries docs only.
+type streamSink struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	layout *layout
+	// encoder is created on first use, as CSV columns are resolved after the sink
+	encoder docEncoder
+}
+
+func newStreamSink(w io.Writer, l *layout) *streamSink {
+	return &streamSink{
+		w:      bufio.NewWriter(w),
+		layout: l,
+	}
+}
+
+func (s *streamSink) putIndex(ctx context.Context, meta indexMeta) error {
+	return nil
+}
+
+func (s *streamSink) openWindow(ctx context.Context, w window) (windowWriter, error) {
+	return s, nil
+}
+
+// pagesDurable is true as every page is flushed once written
+func (s *streamSink) pagesDurable() bool {
+	return true
+}
+
+// committed is false as streamed docs cannot be looked back on
+func (s *streamSink) committed(w window) (int64, bool) {
+	return 0, false
+}
+
+func (s *streamSink) flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	encoder, err := s.encoderOf()
+	if err != nil {
+		return err
+	}
+	if err = encoder.flush(); err != nil {
+		return errors.Wrap(err, "write stdout error")
+	}
+	if err = s.w.Flush(); err != nil {
+		return errors.Wrap(err, "write stdout error")
+	}
+	return nil
+}
+
+// encoderOf returns the encoder, it should be called with s.mu held
+func (s *streamSink) encoderOf() (docEncoder, error) {
+	if s.encoder == nil {
+		encoder, err := s.layout.encoder(s.w)
+		if err != nil {
+			return nil, err
+		}
+		s.encoder = encoder
+	}
+	return s.encoder, nil
+}
+
+// write writes a page at once, so that pages written by concurrent windows never interleave
+func (s *streamSink) write(ctx context.Context, hits []*elastic.SearchHit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	encoder, err := s.encoderOf()
+	if err != nil {
+		return err
+	}
+	if err = encoder.encode(hits); err != nil {
+		return errors.Wrap(err, "write stdout error")
+	}
+	if err = s.w.Flush(); err != nil {
+		return errors.Wrap(err, "write stdout error")
+	}
+	return nil
+}
+
+func (s *streamSink) commit(ctx context.Context) error {
+	return nil
+}
+
+func (s *streamSink) abort() {
+}
+
+// stdinStore serves stdin as the only file of a store, so that piped docs are read the same way as a single NDJSON file
+type stdinStore struct{}
+
+func (stdinStore) location(name string) string {
+	return "stdin"
+}
+
+func (s stdinStore) readFile(ctx context.Context, name string) ([]byte, error) {
+	return nil, &os.PathError{Op: "open", Path: s.location(name), Err: os.ErrNotExist}
+}
+
+func (stdinStore) writeFile(ctx context.Context, name string, data []byte) error {
+	return errors.New("stdin is read only")
+}
+
+func (stdinStore) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(os.Stdin), nil
+}
+
+func (stdinStore) create(ctx context.Context, name string) (storeWriter, error) {
+	return nil, errors.New("stdin is read only")
+}
+
+func (stdinStore) cleanup(ctx context.Context) error {
+	return nil
+}
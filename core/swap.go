@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"sort"
+	"time"
+)
+
+// what is done to indices an alias pointed to before it is swapped onto target index
+const (
+	oldIndexKeep   = "keep"
+	oldIndexClose  = "close"
+	oldIndexDelete = "delete"
+)
+
+// swapAlias moves alias Conf.SwapAlias from the indices it points to onto target index by one _aliases request,
+// so that clients of the alias see either the old indices or target index but never both or none.
+// The alias keeps its filter, routing and write index flag. If Conf.VerifyCount is set, the alias is moved
+// only if target index holds as many docs as source.
+func (d *Dumper) swapAlias(ctx context.Context) error {
+	name := d.Conf.SwapAlias
+	if d.Conf.VerifyCount {
+		if err := d.verifyCount(ctx); err != nil {
+			return err
+		}
+	}
+	old, definition, err := d.aliasIndices(ctx, name)
+	if err != nil {
+		return err
+	}
+	add, err := aliasAddAction(name, definition)
+	if err != nil {
+		return err
+	}
+	service := d.TargetClient.Alias()
+	for _, index := range old {
+		service = service.Action(elastic.NewAliasRemoveAction(name).Index(index))
+	}
+	service = service.Action(add.Index(d.TargetIndex))
+	swapCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err = service.Do(swapCtx); err != nil {
+		return requestError(err, d.Conf.Output, "swap alias "+name+" error")
+	}
+	if len(old) == 0 {
+		return nil
+	}
+
+	oldCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	switch d.Conf.OldIndex {
+	case oldIndexClose:
+		for _, index := range old {
+			if _, err = d.TargetClient.CloseIndex(index).Do(oldCtx); err != nil {
+				return requestError(err, d.Conf.Output, "close old index "+index+" error")
+			}
+		}
+	case oldIndexDelete:
+		if _, err = d.TargetClient.DeleteIndex(old...).Do(oldCtx); err != nil {
+			return requestError(err, d.Conf.Output, "delete old indices error")
+		}
+	}
+	return nil
+}
+
+// aliasIndices returns indices other than target index which alias name points to, and definition of the alias
+// on the write index of them, or on any of them if none is the write index. The alias may not exist yet.
+func (d *Dumper) aliasIndices(ctx context.Context, name string) ([]string, map[string]interface{}, error) {
+	getCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	// get index API resolves the alias to the indices it points to, along with definitions of their aliases
+	resp, err := d.TargetClient.IndexGet(name).Do(getCtx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, requestError(err, d.Conf.Output, "get indices of alias "+name+" error")
+	}
+	var (
+		old        []string
+		definition map[string]interface{}
+	)
+	for index, info := range resp {
+		if index == name {
+			return nil, nil, errors.Errorf("%s is an index rather than an alias", name)
+		}
+		if index == d.TargetIndex || info == nil {
+			continue
+		}
+		old = append(old, index)
+		alias, _ := info.Aliases[name].(map[string]interface{})
+		if isWriteIndex, _ := alias["is_write_index"].(bool); isWriteIndex || definition == nil {
+			definition = alias
+		}
+	}
+	sort.Strings(old)
+	return old, definition, nil
+}
+
+// verifyCount checks that target index holds as many docs as source after refreshing it
+func (d *Dumper) verifyCount(ctx context.Context) error {
+	expected, err := d.source.count(ctx)
+	if err != nil {
+		return err
+	}
+	if expected < 0 {
+		return errors.New("count of source docs is unknown, verify count requires an index or a dump directory as input")
+	}
+	countCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err = d.TargetClient.Refresh(d.TargetIndex).Do(countCtx); err != nil {
+		return requestError(err, d.Conf.Output, "refresh target index error")
+	}
+	actual, err := d.TargetClient.Count(d.TargetIndex).Do(countCtx)
+	if err != nil {
+		return requestError(err, d.Conf.Output, "count target docs error")
+	}
+	if actual != expected {
+		return errors.Errorf("verify count failed: source has %d docs but target index %s has %d, alias %s is not swapped",
+			expected, d.TargetIndex, actual, d.Conf.SwapAlias)
+	}
+	return nil
+}
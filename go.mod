@@ -5,7 +5,11 @@ go 1.16
 require (
 	github.com/Jeffail/gabs/v2 v2.6.1
 	github.com/araddon/dateparse v0.0.0-20210429162001-6b43995a97de
+	github.com/klauspost/compress v1.15.9
+	github.com/minio/minio-go/v7 v7.0.19
 	github.com/olivere/elastic/v7 v7.0.32
+	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/schollz/progressbar/v3 v3.8.6
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.1.3
@@ -13,6 +17,8 @@ require (
 	github.com/testcontainers/testcontainers-go v0.11.0
 	github.com/unionj-cloud/go-doudou v1.1.6
 	github.com/wubin1989/go-esutils/v2 v2.0.1-0.20220614094125-1bbe21d8edbe
+	github.com/xitongsys/parquet-go v1.6.2
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 )
 
 replace github.com/olivere/elastic/v7 v7.0.32 => github.com/wubin1989/elastic/v7 v7.0.33